@@ -5,6 +5,7 @@ import (
 	"context"
 	"runtime"
 	"slices"
+	"strings"
 	
 	urcli "github.com/urfave/cli/v2"
 	"github.com/gookit/color"
@@ -34,23 +35,30 @@ func Execute() {
 	cli := &urcli.App{
 		Name:	"irgen",
 		Version: common.Version,
-		Flags: []urcli.Flag{
-			&urcli.StringFlag{
-				Name:	"input",
-				Aliases: []string{"i"},
-				Usage:   "file path or URL of an HTML article",
+		Flags: newFlags(m),
+		Commands: []*urcli.Command{
+			{
+				Name:  "outline",
+				Usage: "print the sections of the inputs and the notes that would be made from them, without importing anything",
+				ArgsUsage: "[options] <input>...",
+				// instances of their own, urfave/cli keeping the state of a flag in it
+				Flags: newFlags(m),
+				Action: func(c *urcli.Context) error {
+					m.Config.Outline = true
+					run(c, m)
+					return nil
+				},
 			},
-			&urcli.IntFlag{
-				Name:  "max-titles",
-				Value: m.Config.MaxTitles,
-			},
-			&urcli.IntFlag{
-				Name:  "res-x-max",
-				Value: m.Config.ResXMax,
-			},
-			&urcli.IntFlag{
-				Name:  "res-y-max",
-				Value: m.Config.ResYMax,
+			{
+				Name:  "pin",
+				Usage: "list the images of the inputs section by section and save those picked as pins in config.json",
+				ArgsUsage: "[options] <input>...",
+				Flags: newFlags(m),
+				Action: func(c *urcli.Context) error {
+					m.Config.PinPreview = true
+					run(c, m)
+					return nil
+				},
 			},
 		},
 		Action: func(c *urcli.Context) error {
@@ -62,16 +70,156 @@ func Execute() {
 	cli.Run(os.Args)
 }
 
+func newFlags(m *meta.Meta) []urcli.Flag {
+	return []urcli.Flag{
+		&urcli.StringSliceFlag{
+			Name:	"input",
+			Aliases: []string{"i"},
+			Usage:   "file path, directory, glob or URL of an article, can be repeated",
+		},
+		&urcli.StringFlag{
+			Name:  "from-file",
+			Usage: "file listing the inputs to process, one per line, optionally followed by \"| deck=... | tags=...\"",
+		},
+		&urcli.StringFlag{
+			Name:  "book",
+			Usage: "import the inputs, in order, as the chapters of a single book of the given title (a single input is taken as its table of contents)",
+		},
+		&urcli.StringFlag{
+			Name:  "crawl-next",
+			Usage: "crawl an online book from the given URL by following the links matched by this CSS selector, e.g. \"a[rel=next]\"",
+		},
+		&urcli.IntFlag{
+			Name:  "max-pages",
+			Value: 100,
+			Usage: "maximum number of pages to crawl, 0 for no limit",
+		},
+		&urcli.BoolFlag{
+			Name:  "any-host",
+			Usage: "let the crawl follow links to other websites",
+		},
+		&urcli.StringFlag{
+			Name:  "content-selector",
+			Value: m.Config.ContentSelector,
+			Usage: "CSS selector of the content of web pages unknown to the extractors, defaults to the whole body",
+		},
+		&urcli.BoolFlag{
+			Name:  "wiki-members",
+			Usage: "import every article of the given Wikipedia category or \"List of ...\" page, each in a subdeck",
+		},
+		&urcli.IntFlag{
+			Name:  "category-depth",
+			Usage: "with --wiki-members, how many levels of subcategories to explore",
+		},
+		&urcli.StringFlag{
+			Name:  "deck",
+			Value: m.Config.Deck,
+			Usage: "deck to put the notes in instead of the one named after the input",
+		},
+		&urcli.StringFlag{
+			Name:  "tags",
+			Value: strings.Join(m.Config.Tags, ","),
+			Usage: "comma-separated tags to add to the notes",
+		},
+		&urcli.IntFlag{
+			Name:  "max-titles",
+			Value: m.Config.MaxTitles,
+		},
+		&urcli.IntFlag{
+			Name:  "res-x-max",
+			Value: m.Config.ResXMax,
+		},
+		&urcli.IntFlag{
+			Name:  "res-y-max",
+			Value: m.Config.ResYMax,
+		},
+		&urcli.StringFlag{
+			Name:  "charset",
+			Value: m.Config.Charset,
+			Usage: "force the character encoding of the input (e.g. windows-1252, shift_jis), detected if empty",
+		},
+		&urcli.StringFlag{
+			Name:  "infer-headings",
+			Value: strings.Join(m.Config.HeadingInference, ","),
+			Usage: "rules to find the headings of documents without heading tags, among aria, class, fontsize and bold (empty to disable)",
+		},
+		&urcli.BoolFlag{
+			Name:  "preview-headings",
+			Usage: "only show the outline of the inputs, with the headings that were inferred, without making notes",
+		},
+		&urcli.IntFlag{
+			Name:  "max-section-size",
+			Value: m.Config.MaxSectionSize,
+			Usage: "cut sections longer than that into several notes, between paragraphs (0 for no limit)",
+		},
+		&urcli.StringFlag{
+			Name:  "section-size-unit",
+			Value: m.Config.SectionSizeUnit,
+			Usage: "unit of --max-section-size: words or characters",
+		},
+		&urcli.IntFlag{
+			Name:  "min-section-size",
+			Value: m.Config.MinSectionSize,
+			Usage: "merge sections smaller than that into another note (0 to never merge)",
+		},
+		&urcli.StringFlag{
+			Name:  "merge-into",
+			Value: m.Config.MergeInto,
+			Usage: "note in which tiny sections are merged: previous (sibling) or parent",
+		},
+		&urcli.StringFlag{
+			Name:  "cut-at",
+			Value: m.Config.CutSelector,
+			Usage: "CSS selector of the elements at which sections are cut into several notes, e.g. \"hr\"",
+		},
+		&urcli.StringFlag{
+			Name:  "move-to-context",
+			Value: m.Config.MoveToContext,
+			Usage: "move the images and tables of the text of the notes to their context: trailing (those at the bottom) or all",
+		},
+		&urcli.BoolFlag{
+			Name:  "skip-overview",
+			Value: m.Config.SkipOverview,
+			Usage: "don't make a note of the lead section, before the first heading",
+		},
+		&urcli.StringSliceFlag{
+			Name:  "include",
+			Value: urcli.NewStringSlice(m.Config.Include...),
+			Usage: "only import the sections whose heading path matches this glob (\"Anatomy/**\") or regex (\"re:...\"), can be repeated",
+		},
+		&urcli.StringSliceFlag{
+			Name:  "exclude",
+			Value: urcli.NewStringSlice(m.Config.Exclude...),
+			Usage: "leave out the sections whose heading path matches this glob (\"*/Etymology\") or regex (\"re:...\"), can be repeated",
+		},
+		&urcli.BoolFlag{
+			Name:  "chapter-decks",
+			Value: m.Config.ChapterDecks,
+			Usage: "for documents made of chapters (e.g. EPUB), put each chapter in a subdeck of its own",
+		},
+		&urcli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "run the whole import but write nothing, only report which notes would be added, updated or skipped",
+		},
+		&urcli.BoolFlag{
+			Name:  "update",
+			Value: m.Config.Update,
+			Usage: "rewrite the RealTitle and Context of the notes already in the deck, which are otherwise left as they are",
+		},
+	}
+}
+
 func run(c *urcli.Context, m *meta.Meta) {
 	platform := runtime.GOOS+"/"+runtime.GOARCH
 	m.Log.Trace().Strs("os.Args", os.Args).Str("platform", platform).Msg("")
+	mustStartAsGUI := c.NArg() == 0 && !c.IsSet("input") && !c.IsSet("from-file") && !m.Config.Outline && !m.Config.PinPreview
 	m.Log.Debug().
-		Bool("mustStartAsGUI?", c.NArg() == 0 && !c.IsSet("input")).
+		Bool("mustStartAsGUI?", mustStartAsGUI).
 		Int("c.NArg()", c.NArg()).
 		Bool("inputFlagPassed", c.IsSet("input")).
 		Bool("GUIsupported", slices.Contains(supported, platform)).
 		Msg("")
-	if c.NArg() == 0 && !c.IsSet("input") {
+	if mustStartAsGUI {
 		if !slices.Contains(supported, platform) {
 			m.Log.Fatal().Msgf("GUI not supported on this platform: %s. This is CLI binary.", platform)
 		}
@@ -81,19 +229,98 @@ func run(c *urcli.Context, m *meta.Meta) {
 	}
 	// copy/dl img will occur before the final addNote import,
 	// hence should set MediaDir already
-	if ok := common.QueryAnkiConnectMediaDir(m); ok {
+	if m.Config.Outline || m.Config.PinPreview || c.Bool("dry-run") {
+		// the outline and the pin preview write nothing either, and none needs collection.media
+		m.Config.DryRun = true
+	} else if ok := common.QueryAnkiConnectMediaDir(m); ok {
 		m.Log.Info().Msg("AnkiConnect detected")
 	}
+	m.Config.Update = c.Bool("update")
 	m.Config.MaxTitles = c.Int("max-titles")
 	m.Config.ResXMax = c.Int("res-x-max")
 	m.Config.ResYMax = c.Int("res-y-max")
+	m.Config.Charset = c.String("charset")
+	m.Config.ChapterDecks = c.Bool("chapter-decks")
+	m.Config.Deck = c.String("deck")
+	m.Config.ContentSelector = c.String("content-selector")
+	m.Config.HeadingInference = strings.FieldsFunc(c.String("infer-headings"), func(r rune) bool { return r == ',' || r == ' ' })
+	m.Config.PreviewHeadings = c.Bool("preview-headings")
+	m.Config.CutSelector = c.String("cut-at")
+	m.Config.MaxSectionSize = c.Int("max-section-size")
+	m.Config.SectionSizeUnit = c.String("section-size-unit")
+	m.Config.MinSectionSize = c.Int("min-section-size")
+	m.Config.MergeInto = c.String("merge-into")
+	m.Config.SkipOverview = c.Bool("skip-overview")
+	m.Config.MoveToContext = c.String("move-to-context")
+	if mv := m.Config.MoveToContext; mv != "" && mv != "trailing" && mv != "all" {
+		m.Log.Fatal().Str("mode", mv).Msg("the images and tables moved to the context are either the trailing ones or all")
+	}
+	m.Config.Include = c.StringSlice("include")
+	m.Config.Exclude = c.StringSlice("exclude")
+	if into := m.Config.MergeInto; into != "previous" && into != "parent" {
+		m.Log.Fatal().Str("into", into).Msg("tiny sections can be merged into the previous or the parent one")
+	}
+	if unit := m.Config.SectionSizeUnit; unit != "words" && unit != "characters" {
+		m.Log.Fatal().Str("unit", unit).Msg("the size of sections is counted in words or characters")
+	}
+	m.Config.Tags = strings.FieldsFunc(c.String("tags"), func(r rune) bool { return r == ',' || r == ' ' })
 
-	m.Targ = c.String("input")
-	if m.Targ == "" && c.Args().First() != "" {
-		m.Targ = c.Args().First()
+	var inputs []core.InputType
+	for _, targ := range append(c.StringSlice("input"), c.Args().Slice()...) {
+		inputs = append(inputs, core.InputType{Targ: targ})
 	}
-	
-	if success := core.Execute(context.TODO(), m); !success {
+	if c.IsSet("from-file") {
+		listed, err := core.ReadInputList(c.String("from-file"))
+		if err != nil {
+			m.Log.Fatal().Err(err).Msg("couldn't read the list of inputs")
+		}
+		inputs = append(inputs, listed...)
+	}
+	inputs, err := core.ExpandInputs(inputs)
+	if err != nil {
+		m.Log.Fatal().Err(err).Msg("couldn't resolve the inputs")
+	}
+	if len(inputs) == 0 {
+		m.Log.Fatal().Msg("no input given")
+	}
+	if c.Bool("wiki-members") {
+		var failed int
+		for _, input := range inputs {
+			if success := core.ExecuteWikiCollection(context.TODO(), m, input.Targ, c.Int("category-depth")); !success {
+				failed++
+			}
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+	if c.IsSet("crawl-next") {
+		if len(inputs) != 1 {
+			m.Log.Fatal().Msg("the crawl needs a single start URL")
+		}
+		crawl := core.CrawlType{
+			Start: inputs[0].Targ,
+			Next: c.String("crawl-next"),
+			MaxPages: c.Int("max-pages"),
+			SameHost: !c.Bool("any-host"),
+		}
+		if success := core.ExecuteCrawl(context.TODO(), m, c.String("book"), crawl); !success {
+			os.Exit(1)
+		}
+		return
+	}
+	if c.IsSet("book") {
+		var targs []string
+		for _, input := range inputs {
+			targs = append(targs, input.Targ)
+		}
+		if success := core.ExecuteBook(context.TODO(), m, c.String("book"), targs); !success {
+			os.Exit(1)
+		}
+		return
+	}
+	if failed := core.ExecuteAll(context.TODO(), m, inputs); failed > 0 {
 		os.Exit(1)
 	}
 }
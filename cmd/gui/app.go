@@ -42,12 +42,14 @@ func (a *App) Process(params ProcessParams) string {
 		Int("MaxXResolution", params.MaxXResolution).
 		Int("MaxYResolution", params.MaxYResolution).
 		Msg("Parameters provided by GUI")
-	a.m.Targ = params.URL
-	a.m.Config.MaxTitles = params.NumberOfTitle
-	a.m.Config.ResXMax = params.MaxXResolution
-	a.m.Config.ResYMax = params.MaxYResolution
-	if success := core.Execute(a.ctx, a.m); !success {
-		a.m.Log.Error().Msg("Task failed as a result of the error")
+	// each article gets its own copy of the parameters so that several can be processed at once
+	m := *a.m
+	m.Targ = params.URL
+	m.Config.MaxTitles = params.NumberOfTitle
+	m.Config.ResXMax = params.MaxXResolution
+	m.Config.ResYMax = params.MaxYResolution
+	if success := core.Execute(a.ctx, &m); !success {
+		m.Log.Error().Str("Targ", params.URL).Msg("Task failed as a result of the error")
 	}
 	return "" // FIXME rm?
 }
@@ -65,6 +67,22 @@ func (a *App) OpenFileDialog() (string, error) {
                 DisplayName: "HTML Files (*.html;*.htm)",
                 Pattern:     "*.html;*.htm",
             },
+            {
+                DisplayName: "EPUB Files (*.epub)",
+                Pattern:     "*.epub",
+            },
+            {
+                DisplayName: "Word / LibreOffice Documents (*.docx;*.odt)",
+                Pattern:     "*.docx;*.odt",
+            },
+            {
+                DisplayName: "Markdown Files (*.md;*.markdown)",
+                Pattern:     "*.md;*.markdown",
+            },
+            {
+                DisplayName: "Web Archives (*.mhtml;*.mht)",
+                Pattern:     "*.mhtml;*.mht",
+            },
             {
                 DisplayName: "All Files (*.*)",
                 Pattern:     "*.*",
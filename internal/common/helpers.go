@@ -134,6 +134,49 @@ func AddNote(m *meta.Meta, deckName string, modelName string, fields map[string]
 	return err
 }
 
+func UpdateNoteFields(m *meta.Meta, id int64, fields map[string]string) error {
+	params := map[string]interface{}{
+		"note": map[string]interface{}{
+			"id":     id,
+			"fields": fields,
+		},
+	}
+
+	_, err := SendAnkiConnectRequest(m, "updateNoteFields", params)
+	return err
+}
+
+type NoteInfo struct {
+	NoteID	int64	`json:"noteId"`
+	Fields	map[string]struct {
+		Value string `json:"value"`
+	} `json:"fields"`
+}
+
+// FindNotes returns the notes matching the query, written in the search syntax of Anki
+func FindNotes(m *meta.Meta, query string) ([]NoteInfo, error) {
+	response, err := SendAnkiConnectRequest(m, "findNotes", map[string]interface{}{"query": query})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find notes: %w", err)
+	}
+	var ids []int64
+	if err := json.Unmarshal(response, &ids); err != nil {
+		return nil, fmt.Errorf("failed to parse findNotes response: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	response, err = SendAnkiConnectRequest(m, "notesInfo", map[string]interface{}{"notes": ids})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get notes info: %w", err)
+	}
+	var notes []NoteInfo
+	if err := json.Unmarshal(response, &notes); err != nil {
+		return nil, fmt.Errorf("failed to parse notesInfo response: %w", err)
+	}
+	return notes, nil
+}
+
 func SendAnkiConnectRequest(m *meta.Meta, action string, params interface{}) (json.RawMessage, error) {
 	var lastErr error
 	
@@ -289,6 +332,10 @@ func DownloadFiles(ctx context.Context, m *meta.Meta, URLs, filenames []string)
 	if total == 0 {
 		return nil
 	}
+	if m.Config.DryRun {
+		m.Log.Info().Int("images", total).Msg("dry run: images not downloaded")
+		return nil
+	}
 	current := 0
 	failed := 0
 	startTime := time.Now()
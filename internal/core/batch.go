@@ -0,0 +1,194 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tassa-yoniso-manasi-karoto/irgen/internal/meta"
+)
+
+// InputType is one job of a batch: a path or URL, with optionally the deck
+// and tags its notes must get instead of the default ones.
+type InputType struct {
+	Targ, Deck	string
+	Tags		[]string
+}
+
+var htmlExts = []string{".html", ".htm", ".xhtml"}
+
+/*
+ReadInputList parses a list of inputs, one per line. Each line can override
+the deck and the tags of its notes, with fields separated by "|":
+
+	https://en.wikipedia.org/wiki/Ulna | deck=Anatomy::Bones | tags=bones,forearm
+
+Empty lines and lines starting with "#" are ignored.
+*/
+func ReadInputList(path string) (inputs []InputType, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for i := 1; scanner.Scan(); i++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "|")
+		input := InputType{Targ: strings.TrimSpace(fields[0])}
+		for _, field := range fields[1:] {
+			key, value, found := strings.Cut(strings.TrimSpace(field), "=")
+			switch {
+			case !found:
+				return nil, fmt.Errorf("%s:%d: expected key=value, got %q", path, i, field)
+			case key == "deck":
+				input.Deck = strings.TrimSpace(value)
+			case key == "tags":
+				input.Tags = splitTags(value)
+			default:
+				return nil, fmt.Errorf("%s:%d: unknown key %q", path, i, key)
+			}
+		}
+		inputs = append(inputs, input)
+	}
+	return inputs, scanner.Err()
+}
+
+// tags can't contain spaces in Anki
+func splitTags(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' })
+}
+
+// ExpandInputs resolves globs and directories into as many inputs as there are
+// files that irgen supports beneath them and makes local paths absolute.
+func ExpandInputs(inputs []InputType) (expanded []InputType, err error) {
+	for _, input := range inputs {
+		if isURL(input.Targ) {
+			expanded = append(expanded, input)
+			continue
+		}
+		paths := []string{input.Targ}
+		if strings.ContainsAny(input.Targ, "*?[") {
+			if paths, err = filepath.Glob(input.Targ); err != nil {
+				return nil, fmt.Errorf("bad pattern %q: %w", input.Targ, err)
+			}
+			if len(paths) == 0 {
+				return nil, fmt.Errorf("no file matches %q", input.Targ)
+			}
+		}
+		for _, p := range paths {
+			if abs, err := filepath.Abs(p); err == nil {
+				p = abs
+			}
+			for _, file := range supportedFiles(p) {
+				x := input
+				x.Targ = file
+				expanded = append(expanded, x)
+			}
+		}
+	}
+	return
+}
+
+// supportedFiles returns p itself unless it is a directory that can't be read as a
+// single document (see ReaderType.Folders), in which case its files are listed
+func supportedFiles(p string) (files []string) {
+	info, err := os.Stat(p)
+	if err != nil || !info.IsDir() {
+		return []string{p}
+	}
+	if _, ok := folderReaderFor(p); ok {
+		return []string{p}
+	}
+	filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		_, ok := readerFor(path)
+		if ok || contains(htmlExts, strings.ToLower(filepath.Ext(path))) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	slices.Sort(files)
+	return
+}
+
+func isURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// ExecuteAll runs each input as an independent job and returns how many failed.
+func ExecuteAll(ctx context.Context, m *meta.Meta, inputs []InputType) (failed int) {
+	return executeAll(ctx, m, inputs, nil)
+}
+
+// executeAll is ExecuteAll with done, if not nil, called after each successful job
+func executeAll(ctx context.Context, m *meta.Meta, inputs []InputType, done func(InputType)) (failed int) {
+	if len(inputs) == 1 {
+		if _, ok := executeInput(ctx, m, inputs[0]); !ok {
+			failed++
+		} else if done != nil {
+			done(inputs[0])
+		}
+		return
+	}
+	type result struct {
+		input	InputType
+		notes	int
+		ok	bool
+		elapsed	time.Duration
+	}
+	var results []result
+	var total int
+	for i, input := range inputs {
+		m.Log.Info().Msgf("Job %d/%d: %s", i+1, len(inputs), input.Targ)
+		launch := time.Now()
+		notes, ok := executeInput(ctx, m, input)
+		results = append(results, result{input, notes, ok, time.Since(launch)})
+		total += notes
+		if !ok {
+			failed++
+		} else if done != nil {
+			done(input)
+		}
+	}
+	m.Log.Info().Msg("Summary:")
+	for _, r := range results {
+		event := m.Log.Info()
+		status := "OK"
+		if !r.ok {
+			event = m.Log.Error()
+			status = "FAILED"
+		}
+		event.Int("notes", r.notes).Str("elapsed", r.elapsed.Round(time.Millisecond).String()).Msgf("%-6s %s", status, r.input.Targ)
+	}
+	m.Log.Info().
+		Int("jobs", len(inputs)).
+		Int("failed", failed).
+		Int("total notes", total).
+		Msg("Batch done")
+	return
+}
+
+// the deck and tags of the input override the config of its job
+func executeInput(ctx context.Context, m *meta.Meta, input InputType) (notes int, success bool) {
+	j := NewJob(m)
+	j.m.Targ = input.Targ
+	if input.Deck != "" {
+		j.m.Config.Deck = input.Deck
+	}
+	if len(input.Tags) != 0 {
+		j.m.Config.Tags = input.Tags
+	}
+	return j.execute(ctx)
+}
@@ -0,0 +1,158 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadInputList(t *testing.T) {
+	tests := []struct {
+		name, list	string
+		want		string
+		wantErr		string
+	}{
+		{
+			name: "comments and blank lines",
+			list: "# anatomy\n\nhttps://en.wikipedia.org/wiki/Ulna | deck=Anatomy::Bones | tags=bones,forearm\n   \n  ulna.html  \n# radius.html\nradius.docx|tags=one two\n",
+			want: "[{https://en.wikipedia.org/wiki/Ulna Anatomy::Bones [bones forearm]} {ulna.html  []} {radius.docx  [one two]}]",
+		},
+		{
+			name: "empty",
+			list: "# nothing yet\n",
+			want: "[]",
+		},
+		{
+			name: "field without value",
+			list: "ulna.html\nradius.html | deck\n",
+			wantErr: `list.txt:2: expected key=value, got " deck"`,
+		},
+		{
+			name: "unknown key",
+			list: "ulna.html | color=red\n",
+			wantErr: `list.txt:1: unknown key "color"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), "list.txt")
+			if err := os.WriteFile(p, []byte(tt.list), 0644); err != nil {
+				t.Fatal(err)
+			}
+			inputs, err := ReadInputList(p)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasSuffix(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fmt.Sprint(inputs); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+	if _, err := ReadInputList(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("expected an error for a missing list")
+	}
+}
+
+func TestExpandInputs(t *testing.T) {
+	dir := t.TempDir()
+	// a directory with markdown files in it, even deep down, is read as a single document
+	for _, name := range []string{"a.html", "b.HTM", "c.docx", "notes.txt", "sub/d.xhtml", "sub/e.png", "book/1.md", "book/2.md"} {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name	string
+		inputs	[]InputType
+		want	[]string
+		wantErr	bool
+	}{
+		{
+			name: "URL",
+			inputs: []InputType{{Targ: "https://en.wikipedia.org/wiki/Ulna"}},
+			want: []string{"https://en.wikipedia.org/wiki/Ulna"},
+		},
+		{
+			name: "glob",
+			inputs: []InputType{{Targ: filepath.Join(dir, "*.html")}},
+			want: []string{"a.html"},
+		},
+		{
+			name: "files of a directory, unlike those of a glob, filtered",
+			inputs: []InputType{{Targ: filepath.Join(dir, "sub")}, {Targ: filepath.Join(dir, "*.*")}},
+			want: []string{"sub/d.xhtml", "a.html", "b.HTM", "c.docx", "notes.txt"},
+		},
+		{
+			name: "directory read as a single document",
+			inputs: []InputType{{Targ: filepath.Join(dir, "book")}},
+			want: []string{"book"},
+		},
+		{
+			name: "no match",
+			inputs: []InputType{{Targ: "https://en.wikipedia.org/wiki/Ulna"}, {Targ: filepath.Join(dir, "*.pdf")}},
+			wantErr: true,
+		},
+		{
+			name: "bad pattern",
+			inputs: []InputType{{Targ: filepath.Join(dir, "[")}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expanded, err := ExpandInputs(tt.inputs)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %v, want an error", expanded)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, input := range expanded {
+				if rel, err := filepath.Rel(dir, input.Targ); err == nil && !isURL(input.Targ) {
+					input.Targ = filepath.ToSlash(rel)
+				}
+				got = append(got, input.Targ)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandInputsKeepsDeckAndTags(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.html", "b.html"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expanded, err := ExpandInputs([]InputType{{Targ: dir, Deck: "Bones", Tags: []string{"arm"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expanded) != 2 {
+		t.Fatalf("got %v, want 2 inputs", expanded)
+	}
+	for _, input := range expanded {
+		if input.Deck != "Bones" || fmt.Sprint(input.Tags) != "[arm]" {
+			t.Errorf("got %+v, want the deck and tags of the directory", input)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"github.com/tassa-yoniso-manasi-karoto/irgen/internal/meta"
+	"github.com/tassa-yoniso-manasi-karoto/irgen/internal/common"
+)
+
+/*
+A book is made of several documents (files or URLs) that are imported as the
+chapters of a single one: each is put under a synthetic <h1> of its title so
+that Locations, RealTitles and the scopes of the capillaries run across
+chapter boundaries, as if the book had been a single document to begin with.
+*/
+
+type bookChapter struct {
+	Targ, Title string
+}
+
+// ExecuteBook imports targs, in order, as the chapters of the book titled title.
+// A single targ is taken as the table of contents of the book: the pages it
+// links to are the chapters.
+func ExecuteBook(ctx context.Context, m *meta.Meta, title string, targs []string) (success bool) {
+	var chapters []bookChapter
+	if len(targs) == 1 {
+		var err error
+		if chapters, err = tocChapters(m, targs[0]); err != nil {
+			m.Log.Error().Err(err).Str("toc", targs[0]).Msg("couldn't read the table of contents")
+			return
+		}
+		m.Log.Info().Int("chapters", len(chapters)).Msg("Table of contents read")
+	} else {
+		for _, targ := range targs {
+			chapters = append(chapters, bookChapter{Targ: targ})
+		}
+	}
+	src := SourceType{
+		Media: make(map[string][]byte),
+		Downloads: make(map[string]string),
+	}
+	var remote *ExtractorType
+	prefix := safeFilename(title)
+	for i, chapter := range chapters {
+		m.Log.Info().Msgf("Chapter %d/%d: %s", i+1, len(chapters), chapter.Targ)
+		c, x, err := src.loadChapter(m, chapter, prefix)
+		if err != nil {
+			m.Log.Error().Err(err).Str("chapter", chapter.Targ).Msg("couldn't load chapter")
+			return
+		}
+		if x != nil {
+			if remote != nil && remote.Name != x.Name {
+				m.Log.Warn().Msg("chapters from different websites: only the images of the first one can be processed")
+			} else {
+				remote = x
+			}
+		}
+		src.Chapters = append(src.Chapters, c)
+	}
+	if len(src.Chapters) == 0 {
+		m.Log.Error().Msg("the book has no chapter")
+		return
+	}
+	return src.executeJoined(ctx, m, title, remote, src.JoinAsBook())
+}
+
+// executeJoined runs the pipeline over file, the chapters of src joined. Chapters that
+// come from a website known by an extractor still need its processing of images.
+func (src SourceType) executeJoined(ctx context.Context, m *meta.Meta, title string, remote *ExtractorType, file []byte) (success bool) {
+	j := NewJob(m)
+	j.Extractor = src.extractor("Book")
+	if remote != nil {
+		j.Extractor.MustSkip = remote.MustSkip
+		packaged := j.Extractor.IMGProcessor
+		j.Extractor.IMGProcessor = func(ctx context.Context, m *meta.Meta, n *goquery.Selection) {
+			packaged(ctx, m, n)
+			remote.IMGProcessor(ctx, m, n)
+		}
+	}
+	j.Article = ArticleType{Name: title}
+	j.deckName = title
+	if m.Config.Deck != "" {
+		j.deckName = m.Config.Deck
+	}
+	j.outFile = filepath.Join(m.Config.DestDir, safeFilename(title) + ".txt")
+	_, success = j.process(ctx, file)
+	return
+}
+
+// loadChapter gets the content of the chapter and collects its images in src. The
+// extractor of the website the chapter is from, if any, is returned.
+func (src *SourceType) loadChapter(m *meta.Meta, chapter bookChapter, prefix string) (c ChapterType, remote *ExtractorType, err error) {
+	c.Title = chapter.Title
+	var content *goquery.Selection
+	if isURL(chapter.Targ) {
+		file, contentType, err := fetch(m, chapter.Targ)
+		if err != nil {
+			return c, nil, err
+		}
+		if file, _, err = toUTF8(file, contentType, m.Config.Charset); err != nil {
+			return c, nil, err
+		}
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(file))
+		if err != nil {
+			return c, nil, err
+		}
+		return src.chapterFromPage(m, chapter, doc, prefix)
+	} else if reader, ok := readerFor(chapter.Targ); ok {
+		sub, err := reader.Read(m, chapter.Targ)
+		if err != nil {
+			return c, nil, err
+		}
+		for filename, data := range sub.Media {
+			src.Media[filename] = data
+		}
+		if c.Title == "" && len(sub.Chapters) != 0 {
+			c.Title = sub.Chapters[0].Title
+		}
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(sub.Join()))
+		if err != nil {
+			return c, nil, err
+		}
+		content = doc.Find("body")
+	} else {
+		file, err := os.ReadFile(chapter.Targ)
+		if err != nil {
+			return c, nil, err
+		}
+		if file, _, err = toUTF8(file, "", m.Config.Charset); err != nil {
+			return c, nil, err
+		}
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(file))
+		if err != nil {
+			return c, nil, err
+		}
+		if c.Title == "" {
+			c.Title = strings.TrimSpace(doc.Find("title").First().Text())
+		}
+		content = doc.Find("body")
+		for filename, data := range extractDataURIs(content, prefix) {
+			src.Media[filename] = data
+		}
+		src.copyImgs(m, content, filepath.Dir(chapter.Targ), prefix)
+	}
+	if c.Title == "" {
+		c.Title = strings.TrimSuffix(path.Base(chapter.Targ), path.Ext(chapter.Targ))
+	}
+	inner, err := content.Html()
+	if err != nil {
+		return
+	}
+	c.HTML = []byte("<html><body>" + inner + "</body></html>")
+	return
+}
+
+// chapterFromPage extracts the content of the web page doc, using the extractor of the
+// website if there is one, and otherwise the content selector of the config.
+func (src *SourceType) chapterFromPage(m *meta.Meta, chapter bookChapter, doc *goquery.Document, prefix string) (c ChapterType, remote *ExtractorType, err error) {
+	c.Title = chapter.Title
+	if c.Title == "" {
+		c.Title = strings.TrimSpace(doc.Find("title").First().Text())
+	}
+	var content *goquery.Selection
+	if x, article, ok := matchExtractor(chapter.Targ); ok {
+		x.Clean(doc, article.Lang)
+		content = doc.Find(x.ContentSelector).First()
+		if article.Name != "" && chapter.Title == "" {
+			c.Title = article.Name
+		}
+		remote = &x
+	} else {
+		selector := "body"
+		if m.Config.ContentSelector != "" {
+			selector = m.Config.ContentSelector
+		}
+		if content = doc.Find(selector).First(); content.Length() == 0 {
+			return c, nil, fmt.Errorf("content selector %q matches nothing", selector)
+		}
+		src.downloadImgs(content, chapter.Targ, prefix)
+	}
+	inner, err := content.Html()
+	if err != nil {
+		return
+	}
+	c.HTML = []byte("<html><body>" + inner + "</body></html>")
+	return
+}
+
+// images of local chapters are resolved from the directory of their file
+func (src *SourceType) copyImgs(m *meta.Meta, content *goquery.Selection, dir, prefix string) {
+	content.Find("img").Each(func(i int, s *goquery.Selection) {
+		ref, _ := s.Attr("src")
+		if ref == "" || strings.Contains(ref, "://") || strings.HasPrefix(ref, "data:") {
+			return
+		}
+		if unescaped, err := url.PathUnescape(ref); err == nil {
+			ref = unescaped
+		}
+		imgPath := filepath.Join(dir, filepath.FromSlash(ref))
+		data, err := os.ReadFile(imgPath)
+		if err != nil {
+			m.Log.Warn().Err(err).Str("path", imgPath).Msg("can't read img to copy")
+			return
+		}
+		filename := mediaFilename(prefix, filepath.Base(dir) + "/" + ref)
+		src.Media[filename] = data
+		s.SetAttr("src", filename)
+		s.RemoveAttr("srcset")
+	})
+}
+
+// images of pages unknown to the extractors are downloaded as they are
+func (src *SourceType) downloadImgs(content *goquery.Selection, page, prefix string) {
+	base, err := url.Parse(page)
+	if err != nil {
+		return
+	}
+	content.Find("img").Each(func(i int, s *goquery.Selection) {
+		ref, _ := s.Attr("src")
+		if ref == "" || strings.HasPrefix(ref, "data:") {
+			return
+		}
+		u, err := base.Parse(ref)
+		if err != nil {
+			return
+		}
+		name, _ := url.PathUnescape(path.Base(u.Path))
+		filename := mediaFilename(prefix, fmt.Sprint(len(src.Downloads), "_", name))
+		src.Downloads[filename] = u.String()
+		s.SetAttr("src", filename)
+		s.RemoveAttr("srcset")
+	})
+}
+
+// tocChapters lists the pages or files linked from the table of contents at targ,
+// in order. Links to other websites are ignored.
+func tocChapters(m *meta.Meta, targ string) (chapters []bookChapter, err error) {
+	var file []byte
+	var contentType string
+	if isURL(targ) {
+		file, contentType, err = fetch(m, targ)
+	} else {
+		file, err = os.ReadFile(targ)
+	}
+	if err != nil {
+		return
+	}
+	if file, _, err = toUTF8(file, contentType, m.Config.Charset); err != nil {
+		return
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(file))
+	if err != nil {
+		return
+	}
+	content := doc.Find("body")
+	if x, _, ok := matchExtractor(targ); ok {
+		content = doc.Find(x.ContentSelector).First()
+	}
+	base, _ := url.Parse(targ)
+	seen := map[string]bool{targ: true}
+	content.Find("a[href]").Each(func(i int, s *goquery.Selection) {
+		href, _ := s.Attr("href")
+		var chapter string
+		if isURL(targ) {
+			u, err := base.Parse(href)
+			if err != nil || u.Host != base.Host {
+				return
+			}
+			u.Fragment = ""
+			chapter = u.String()
+		} else {
+			ref, _, _ := strings.Cut(href, "#")
+			if ref == "" || strings.Contains(ref, "://") {
+				return
+			}
+			if unescaped, err := url.PathUnescape(ref); err == nil {
+				ref = unescaped
+			}
+			chapter = filepath.Join(filepath.Dir(targ), filepath.FromSlash(ref))
+			if !canStat(chapter) {
+				return
+			}
+		}
+		if seen[chapter] {
+			return
+		}
+		seen[chapter] = true
+		chapters = append(chapters, bookChapter{
+			Targ: chapter,
+			Title: strings.Join(strings.Fields(s.Text()), " "),
+		})
+	})
+	if len(chapters) == 0 {
+		err = fmt.Errorf("no link to a chapter found")
+	}
+	return
+}
+
+func downloadMedia(ctx context.Context, m *meta.Meta, downloads map[string]string) {
+	var URLs, filenames []string
+	for filename, URL := range downloads {
+		if canStat(filepath.Join(m.Config.CollectionMedia, filename)) {
+			continue
+		}
+		URLs = append(URLs, URL)
+		filenames = append(filenames, filename)
+	}
+	if err := common.DownloadFiles(ctx, m, URLs, filenames); err != nil {
+		m.Log.Error().Err(err).Msg("some images couldn't be downloaded")
+	}
+}
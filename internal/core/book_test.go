@@ -0,0 +1,95 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJoinAsBook(t *testing.T) {
+	src := SourceType{Chapters: []ChapterType{
+		{Title: "Bones", HTML: []byte(`<html><body><h2>Ulna</h2><p>a</p><h3>Shaft</h3><p>b</p></body></html>`)},
+		{Title: "Joints & Muscles", HTML: []byte(`<html><body><h1>Elbow</h1><h2>Ligaments</h2><h4>Annular</h4><p>c</p></body></html>`)},
+		{HTML: []byte(`<html><body><p>d</p></body></html>`)},
+	}}
+	want := "<html><body>" +
+		"<h1>Bones</h1><h2>Ulna</h2><p>a</p><h3>Shaft</h3><p>b</p>" +
+		"<h1>Joints &amp; Muscles</h1><h2>Elbow</h2><h3>Ligaments</h3><h5>Annular</h5><p>c</p>" +
+		"<h1>Chapter 3</h1><p>d</p>" +
+		"</body></html>"
+	if got := string(src.JoinAsBook()); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestTocChapters(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"toc.html": `<h1>Contents</h1><ol>
+			<li><a href="#top">Top</a></li>
+			<li><a href="ch2.html">Chapter
+				two</a></li>
+			<li><a href="ch1.html#sec">Chapter one</a></li>
+			<li><a href="ch1.html">Chapter one again</a></li>
+			<li><a href="missing.html">Missing</a></li>
+			<li><a href="https://example.org/ch4.html">Elsewhere</a></li>
+			<li><a href="sub/ch%203.html">Chapter three</a></li>
+		</ol>`,
+		"ch1.html": "",
+		"ch2.html": "",
+		"sub/ch 3.html": "",
+		"empty.html": `<p>no link</p>`,
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	chapters, err := tocChapters(testMeta(), filepath.Join(dir, "toc.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range chapters {
+		rel, _ := filepath.Rel(dir, c.Targ)
+		got = append(got, filepath.ToSlash(rel)+" "+c.Title)
+	}
+	want := []string{"ch2.html Chapter two", "ch1.html Chapter one", "sub/ch 3.html Chapter three"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, err := tocChapters(testMeta(), filepath.Join(dir, "empty.html")); err == nil {
+		t.Error("expected an error for a table of contents without links")
+	}
+}
+
+func TestTocChaptersOnline(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, `<a href="toc.html">Contents</a>
+			<a href="ch1.html">One</a>
+			<a href="/book/ch2.html#part">Two</a>
+			<a href="ch1.html#end">One again</a>
+			<a href="https://example.org/book/ch3.html">Elsewhere</a>`)
+	}))
+	defer srv.Close()
+	chapters, err := tocChapters(testMeta(), srv.URL+"/book/toc.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range chapters {
+		got = append(got, strings.TrimPrefix(c.Targ, srv.URL)+" "+c.Title)
+	}
+	want := []string{"/book/ch1.html One", "/book/ch2.html Two"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
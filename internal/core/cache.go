@@ -2,9 +2,9 @@
 package core
 
 import (
-	"regexp"
-	"strconv"
+	"slices"
 	"fmt"
+	"strconv"
 	"strings"
 	"golang.org/x/net/html"
 	
@@ -14,123 +14,184 @@ import (
 )
 
 
-// The following types along with the couple first var declaration 
-// thereafter should help clarify the model of cache in use.
+// The following types along with the registers of Job should help
+// clarify the model of cache in use.
 
  
 // loc of type Location describe the location of a cutpattern in a doc,
 // in relationship with its burying among heading tags (<h1>, <h2>...)
-type Location [7]int
+type Location []int
 /*
-For instance a loc = [7]int{0, 5, 2, 1, 5, 3, 3} translates as:
+For instance a loc = Location{0, 5, 2, 1, 5, 3, 3} translates as:
 After the 5th <h1> of the doc, 2th <h2> of this <h1>, 1th <h3> of this
 <h2>...etc.
 The first element, located at [0], is a purposeless dummy, that
 allows the value of the headings levels (e.g. <h1>) to be aligned 
 with their respectives index values in the loc array (e.g. loc[1]).
-when comments-cuts (on top of currently existing heading-cuts) are implemented (back)
-several cards can be created from the same loc and this idx 0 will serve the
-purpose of keeping track of the nbr of the current of card.
+Several cards can be created from the same loc when a section is too long
+(see splitOversized): idx 0 then keeps track of the nbr of the current card.
+A loc is as long as the heading it is under is deep, which isn't capped at h6
+(see isHeading).
 */
 
-type LocationData struct {
+/*
+The sections of the document make a tree whose root is the document itself and
+whose other nodes are its headings. It is built once by Preprocess so that the
+ancestors, descendants and notes of a section are at hand without walking the
+document again, neither for the titles of the notes nor for their context.
+*/
+type Section struct {
+	// nil for the root
 	Heading			*html.Node
+	// loc of the heading, 0 at idx 0
+	Loc			Location
+	Parent			*Section
+	Children		[]*Section
+	// position of the section among the children of its parent
+	Index			int
+	// cutpatterns of the section, in order
+	Notes			[]*html.Node
+	// an array of results that reflects the array of desired MkCxt-function of the user
+	//! be aware ShrdObjects is used only internally and has no dummy at 0
 	ShrdObjectSlices	[][]ObjectT
+	tree			*SectionTree
+	// sections from the root down to this one
+	path			[]*Section
+	// the descendants are tree.Sections[pre+1:end]
+	pre, end		int
 }
 
-var (
-// CUTPATTERN-NODE TO [7]int LOCATION
-	LocRegister = make(map[*html.Node]Location)	
-// [7]int LOCATION TO 	(1) the ACTUAL POINTER OF THE NODE OF THE HEADING AND
-//                      (2) an array of results from that reflects the array of desired MkCxt-function the user
-	DataRegister = make(map[Location]LocationData)
-)
+type SectionTree struct {
+	Root		*Section
+	// all sections in document order, root first
+	Sections	[]*Section
+	byNote		map[*html.Node]*Section
+}
 
 
-func Preprocess(m *meta.Meta, doc *goquery.Document) {
-	var (
-		currentLoc = new(Location)
-		reHeading = regexp.MustCompile(`h[0-6]`)
-	)
+// Preprocess returns the registers of doc, see Job.
+func Preprocess(m *meta.Meta, doc *goquery.Document) (LocRegister map[*html.Node]Location, tree *SectionTree) {
+	currentLoc := Location{0}
+	LocRegister = make(map[*html.Node]Location)
+	tree = &SectionTree{byNote: make(map[*html.Node]*Section)}
+	tree.Root = tree.add(nil, nil, currentLoc, len(m.Config.Functions))
+	current := tree.Root
 	body := doc.Find("body")
 	d := 0
 	body.Children().Each(func(i int, s *goquery.Selection) {
 		n := s.Nodes[0]
-		hx := reHeading.MatchString(n.Data)
-		if hx {
+		hx := isHeading(n)
+		if hx || n.Data == "merged" {
 			// currentLoc[0] provides the num of the cutpattern
 			// among those that shares the same heading hierachical
 			// position. Reinit it when a heading is found.
 			currentLoc[0] = 0
-			// update loc
-			x, _ := strconv.Atoi(n.Data[1:])
+			// update loc, dropping the count of sub headings if needed
+			x := headingLevel(n)
+			if !hx {
+				// the slot of a section merged into another note: counted
+				// but made no section of
+				x, _ = strconv.Atoi(attr(n, "level"))
+			}
+			for len(currentLoc) <= x {
+				currentLoc = append(currentLoc, 0)
+			}
+			currentLoc = currentLoc[:x+1]
 			currentLoc[x] += 1 
-			
-			// reset the count of sub heading if needed
-			for i := range(currentLoc) {
-				if i > x {
-					currentLoc[i] = 0
-				}
+			if !hx {
+				return
 			}
-			// then update its data and link them in the DataRegister for later
-			//! be aware ShrdObjects is used only internally and has no dummy at 0
-			ObjectSlices := make([][]ObjectT, len(m.Config.Functions))
-			DataRegister[*currentLoc] = LocationData{n, ObjectSlices}
+			// the parent is the closest section of a more important heading
+			parent := current
+			for parent.Heading != nil && headingLevel(parent.Heading) >= x {
+				parent = parent.Parent
+			}
+			current = tree.add(parent, n, currentLoc, len(m.Config.Functions))
 		} else if n.Data == "cutpattern" {
-			LocRegister[n] = *currentLoc
+			LocRegister[n] = slices.Clone(currentLoc)
+			current.Notes = append(current.Notes, n)
+			tree.byNote[n] = current
 			currentLoc[0] += 1 
 		} else {
 			fmt.Printf("CACHE: A \"%s\" tag was encountered during preprocessing.\n", n.Data)
 		}
 		d += 1
 	})
+	tree.Root.close()
+	return
 }
 
+func (tree *SectionTree) add(parent *Section, heading *html.Node, loc Location, functions int) *Section {
+	s := &Section{
+		Heading: heading,
+		Loc: slices.Clone(loc),
+		Parent: parent,
+		ShrdObjectSlices: make([][]ObjectT, functions),
+		tree: tree,
+		pre: len(tree.Sections),
+	}
+	if parent != nil {
+		s.Index = len(parent.Children)
+		parent.Children = append(parent.Children, s)
+		s.path = slices.Clone(parent.path)
+	}
+	s.path = append(s.path, s)
+	tree.Sections = append(tree.Sections, s)
+	return s
+}
 
-// tStack[0] is dummy, tStack[1] is the _first_ heading node preceding n (e.g. h6),
-// tStack[2] is the first heading node preceding tStack[1] with a higher section level (e.g. h5) ...etc
-func (loc Location) Stack() []*html.Node {
-	var (
-		locData LocationData
-		found bool
-		t *html.Node
-		
-		tStack = []*html.Node{nil}
-	)
-	
-	c := make(chan Location)
-	go GetParentLocs(c, loc)
-
-	for parentHeadingLoc := range(c) {
-		locData, found = DataRegister[parentHeadingLoc]
-		if found { 
-			t = locData.Heading
-			tStack = append(tStack, t)
-		}
+// close sets where the descendants of s end in the document order
+func (s *Section) close() int {
+	s.end = s.pre+1
+	for _, child := range s.Children {
+		s.end = child.close()
 	}
-	return tStack
+	return s.end
 }
 
+// Of returns the section the cutpattern belongs to.
+func (tree *SectionTree) Of(cutpattern *html.Node) *Section {
+	if s, ok := tree.byNote[cutpattern]; ok {
+		return s
+	}
+	return tree.Root
+}
 
-// Truncating loc by x (= replacing the x last values of loc array by 0) 
-// will provide the loc (location) of the xth parent.
-func GetParentLocs(c chan Location, loc Location) {
-	for i := range(loc) {
-		// start from the end of the slice ("-1" compensate the dummy at loc[0])
-		currentLvl := len(loc)-i-1
-		// process the truncated loc only if it is filled w/ localisation info
-		if loc[currentLvl] != 0 {
-			c <- loc
-			loc[currentLvl] = 0
-		}
-		if loc.IsEmpty() {
-			break
-		}
+// Depth is 0 for the root, 1 for the sections of the top level headings...etc
+func (s *Section) Depth() int {
+	return len(s.path)-1
+}
+
+// Ancestor returns the xth parent of s, s itself being the 0th, or nil past the root.
+func (s *Section) Ancestor(x int) *Section {
+	if x < 0 || x > s.Depth() {
+		return nil
 	}
-	close(c)
+	return s.path[s.Depth()-x]
 }
 
+// Descendants returns the sections beneath s, in document order.
+func (s *Section) Descendants() []*Section {
+	return s.tree.Sections[s.pre+1 : s.end]
+}
 
+// Path returns the titles of the headings from the top level down to the section.
+func (s *Section) Path() (titles []string) {
+	for _, section := range s.path[1:] {
+		titles = append(titles, Text(section.Heading))
+	}
+	return
+}
+
+// tStack[0] is dummy, tStack[1] is the heading of the section (e.g. h6),
+// tStack[2] is the heading of its parent section (e.g. h5) ...etc
+func (s *Section) Stack() []*html.Node {
+	tStack := []*html.Node{nil}
+	for x := 0; x < s.Depth(); x++ {
+		tStack = append(tStack, s.Ancestor(x).Heading)
+	}
+	return tStack
+}
 
 
 func (loc Location) miniStr() (s string) {
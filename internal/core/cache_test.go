@@ -0,0 +1,102 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPreprocess(t *testing.T) {
+	const doc = `<p>lead</p>` +
+		`<h1>A</h1><p>a</p>` +
+		`<h2>A1</h2><p>a1</p><!-- irgen:cut --><p>a1 bis</p>` +
+		`<h3>A1a</h3><p>a1a</p>` +
+		`<h2>A2</h2><p>a2</p>` +
+		`<h1>B</h1><p>b</p>` +
+		`<h2>B1</h2><h3>B1a</h3><h4>B1a1</h4><h5>B1a1a</h5><h6>B1a1a1</h6><h7>Deep</h7><p>deep</p>`
+	m := testMeta()
+	d := splitDoc(t, doc)
+	cutAtMarkers(m, d)
+	locs, tree := Preprocess(m, d)
+
+	var got []string
+	for _, n := range d.Find("body > cutpattern").Nodes {
+		got = append(got, fmt.Sprint(locs[n], " ", blockText(n)))
+	}
+	want := []string{
+		"[0] lead",
+		"[0 1] a",
+		"[0 1 1] a1",
+		"[1 1 1] a1 bis",
+		"[0 1 1 1] a1a",
+		"[0 1 2] a2",
+		"[0 2] b",
+		"[0 2 1] ",
+		"[0 2 1 1] ",
+		"[0 2 1 1 1] ",
+		"[0 2 1 1 1 1] ",
+		"[0 2 1 1 1 1 1] ",
+		"[0 2 1 1 1 1 1 1] deep",
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got locations\n%q\nwant\n%q", got, want)
+	}
+
+	tests := []struct {
+		title		string
+		path		string
+		depth		int
+		descendants	int
+		notes		int
+	}{
+		{title: "", path: "", depth: 0, descendants: 11, notes: 1},
+		{title: "A", path: "A", depth: 1, descendants: 3, notes: 1},
+		{title: "A1", path: "A/A1", depth: 2, descendants: 1, notes: 2},
+		{title: "A1a", path: "A/A1/A1a", depth: 3, descendants: 0, notes: 1},
+		{title: "A2", path: "A/A2", depth: 2, descendants: 0, notes: 1},
+		{title: "Deep", path: "B/B1/B1a/B1a1/B1a1a/B1a1a1/Deep", depth: 7, descendants: 0, notes: 1},
+	}
+	sections := make(map[string]*Section)
+	for _, s := range tree.Sections {
+		title := ""
+		if s.Heading != nil {
+			title = Text(s.Heading)
+		}
+		sections[title] = s
+	}
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			s, ok := sections[tt.title]
+			if !ok {
+				t.Fatal("no such section")
+			}
+			if got := strings.Join(s.Path(), "/"); got != tt.path {
+				t.Errorf("got path %q, want %q", got, tt.path)
+			}
+			if s.Depth() != tt.depth {
+				t.Errorf("got depth %d, want %d", s.Depth(), tt.depth)
+			}
+			if len(s.Descendants()) != tt.descendants {
+				t.Errorf("got %d descendants, want %d", len(s.Descendants()), tt.descendants)
+			}
+			if len(s.Notes) != tt.notes {
+				t.Errorf("got %d notes, want %d", len(s.Notes), tt.notes)
+			}
+			for _, n := range s.Notes {
+				if tree.Of(n) != s {
+					t.Error("notes of the section not registered to it")
+				}
+			}
+			if s.Ancestor(0) != s || s.Ancestor(s.Depth()) != tree.Root || s.Ancestor(s.Depth()+1) != nil {
+				t.Error("wrong ancestors")
+			}
+			stack := s.Stack()
+			if len(stack) != s.Depth()+1 || (s.Depth() > 0 && stack[1] != s.Heading) {
+				t.Errorf("wrong stack of %d headings", len(stack))
+			}
+		})
+	}
+	if a2 := sections["A2"]; a2.Index != 1 || a2.Parent != sections["A"] {
+		t.Errorf("A2 is child %d of %v, want the 2nd of A", a2.Index, a2.Parent.Path())
+	}
+}
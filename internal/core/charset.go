@@ -0,0 +1,44 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf8"
+	
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+)
+
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// toUTF8 converts the raw bytes of a document to UTF-8, which is what goquery
+// assumes. Unless a charset is forced by the user, the encoding is found
+// following the HTML spec: BOM first, then the charset of the HTTP Content-Type
+// header, then the <meta charset> / http-equiv declarations.
+func toUTF8(file []byte, contentType, forced string) ([]byte, string, error) {
+	var (
+		e encoding.Encoding
+		name string
+		certain bool
+	)
+	if forced != "" {
+		if e, name = charset.Lookup(forced); e == nil {
+			return nil, "", fmt.Errorf("unsupported charset: %q", forced)
+		}
+	} else {
+		e, name, certain = charset.DetermineEncoding(file, contentType)
+		// DetermineEncoding only peeks at the first 1024 bytes and falls back
+		// to windows-1252 when these are plain ASCII: a legacy file that is
+		// nonetheless valid UTF-8 from top to bottom is almost certainly UTF-8
+		if !certain && name == "windows-1252" && utf8.Valid(file) {
+			e, name = encoding.Nop, "utf-8"
+		}
+	}
+	if e != encoding.Nop && name != "utf-8" {
+		var err error
+		if file, err = e.NewDecoder().Bytes(file); err != nil {
+			return nil, name, fmt.Errorf("decoding from %s failed: %w", name, err)
+		}
+	}
+	return bytes.TrimPrefix(file, utf8BOM), name, nil
+}
@@ -0,0 +1,70 @@
+package core
+
+import (
+	"testing"
+)
+
+func TestToUTF8(t *testing.T) {
+	tests := []struct {
+		name, contentType, forced string
+		file []byte
+		want, wantName string
+		wantErr bool
+	}{
+		{
+			name: "plain utf-8",
+			file: []byte("<p>naïve</p>"),
+			want: "<p>naïve</p>",
+			wantName: "utf-8",
+		},
+		{
+			name: "utf-8 BOM is stripped",
+			file: []byte("\xef\xbb\xbf<p>é</p>"),
+			want: "<p>é</p>",
+			wantName: "utf-8",
+		},
+		{
+			name: "meta charset",
+			file: []byte(`<meta charset="windows-1252"><p>caf` + "\xe9" + `</p>`),
+			want: `<meta charset="windows-1252"><p>café</p>`,
+			wantName: "windows-1252",
+		},
+		{
+			name: "content-type header",
+			contentType: "text/html; charset=shift_jis",
+			file: []byte("<p>\x93\xfa\x96\x7b</p>"),
+			want: "<p>日本</p>",
+			wantName: "shift_jis",
+		},
+		{
+			name: "forced charset wins over the declaration",
+			forced: "iso-8859-1",
+			file: []byte(`<meta charset="utf-8"><p>` + "\xe0" + `</p>`),
+			want: `<meta charset="utf-8"><p>à</p>`,
+			wantName: "windows-1252",
+		},
+		{
+			name: "unknown forced charset",
+			forced: "klingon",
+			file: []byte("<p>x</p>"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, name, err := toUTF8(tt.file, tt.contentType, tt.forced)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want || name != tt.wantName {
+				t.Errorf("got %q (%s), want %q (%s)", got, name, tt.want, tt.wantName)
+			}
+		})
+	}
+}
@@ -2,12 +2,14 @@ package core
 
 import (
 	"fmt"
+	"slices"
 	"strconv"
 	"strings"
 	//"os"
 	"golang.org/x/net/html"
 	
 	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
 	"github.com/k0kubun/pp"
 	"github.com/gookit/color"
 	"github.com/rs/zerolog"
@@ -20,84 +22,278 @@ var (
 		"UsingRef": NoteType.UsingRef,
 		"FromSuperior": NoteType.FromSuperior,
 		"FromSuperiorAndDescendants": NoteType.FromSuperiorAndDescendants,
-		//"CaptionInspector": NoteType.CaptionInspector,
+		"CaptionInspector": NoteType.CaptionInspector,
 	}
 	isReusable = map[string]bool {
 		"UsingRef": false,
 		"FromSuperior": true,
 		"FromSuperiorAndDescendants": true,
+		"CaptionInspector": true,
 	}
+	defaultCollect = []string{"img", "table"}
+	defaultContainers = []string{"figure", "div.tmulti"}
+	// the elements removed from the objects whose captions aren't wanted
+	captionSelector = "figcaption, caption, div.thumbcaption, div.gallerytext"
 )
 
 // Originally I used the field Source as Context, hence Capillary was chosen.
 // Put together these functions feed the Source in various volumes with the desired content gathered along.
-type Capillary func(NoteType, []*html.Node, []string, string, int) (ObjectSlice []ObjectT)
+type Capillary func(NoteType, []*html.Node, []string, capillary) (ObjectSlice []ObjectT)
 
+// capillary is a function of the config along with its compiled options
+type capillary struct {
+	meta.Function
+	run Capillary
+	// the elements to gather and their containers to climb to, for the capillaries that have use of it
+	collect, containers goquery.Matcher
+	captions bool
+}
 
-// Move images at the bottom of Text to the beginning of Context (to reimplement)
-// TODO func MvAddendumToCxt(n *html.Node, TXT string) (string, SRC string){
+func compileCapillaries(m *meta.Meta) (capillaries []capillary, err error) {
+	compile := func(selectors []string) (goquery.Matcher, error) {
+		// goquery silently matches nothing with an invalid selector
+		sel, err := cascadia.Compile(strings.Join(selectors, ", "))
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector in %q: %w", selectors, err)
+		}
+		return sel, nil
+	}
+	for _, fn := range m.Config.Functions {
+		c := capillary{Function: fn, captions: true}
+		var ok bool
+		if c.run, ok = mapfunc[fn.Name]; !ok {
+			return nil, fmt.Errorf("unknown capillary %q", fn.Name)
+		}
+		if fn.Captions != nil {
+			c.captions = *fn.Captions
+		}
+		collect := fn.Collect
+		if len(collect) == 0 {
+			collect = defaultCollect
+		}
+		if c.collect, err = compile(collect); err != nil {
+			return nil, fmt.Errorf("%s: %w", fn.Name, err)
+		}
+		// an empty list, unlike a missing one, means not to climb to any container
+		containers := fn.Containers
+		if containers == nil {
+			containers = defaultContainers
+		}
+		if len(containers) != 0 {
+			if c.containers, err = compile(containers); err != nil {
+				return nil, fmt.Errorf("%s: %w", fn.Name, err)
+			}
+		}
+		capillaries = append(capillaries, c)
+	}
+	return
+}
 
 
-func (Note NoteType) MkCxt(m *meta.Meta, loc Location, tStack []*html.Node/*, tRefStack []string*/) (src string) {
+// MvAddendumToCxt moves the images and tables at the bottom of Text ("trailing"), or all
+// of them ("all"), to the beginning of Context, so that the text to read stays compact.
+// Text is left as it is if nothing else than them would remain.
+func (Note NoteType) MvAddendumToCxt(mode string) (txt, src string, objects int) {
+	txt = Note.Txt
+	if mode != "trailing" && mode != "all" {
+		return
+	}
+	clone := Note.QNode.Clone()
+	var moved []*goquery.Selection
+	if mode == "all" {
+		clone.Find("img, table").Each(func(i int, selec *goquery.Selection) {
+			if selec.ParentsFiltered("table").Length() != 0 {
+				return
+			}
+			n := blockOf(selec.Nodes[0], clone.Nodes[0])
+			if p := selec.ParentsFiltered("figure, div.tmulti, div.thumb"); len(p.Nodes) != 0 {
+				n = p.Last().Nodes[0]
+			}
+			if !slices.ContainsFunc(moved, func(s *goquery.Selection) bool { return s.Nodes[0] == n }) {
+				moved = append(moved, &goquery.Selection{Nodes: []*html.Node{n}})
+			}
+		})
+	} else {
+		for c := clone.Nodes[0].LastChild; c != nil; c = c.PrevSibling {
+			if c.Type == html.TextNode && strings.TrimSpace(c.Data) == "" {
+				continue
+			}
+			s := &goquery.Selection{Nodes: []*html.Node{c}}
+			if c.Type != html.ElementNode || strings.TrimSpace(s.Text()) != "" && !s.Is("figure, table, div.tmulti, div.thumb") {
+				break
+			}
+			if !s.Is("img, table") && s.Find("img, table").Length() == 0 {
+				// e.g. an empty paragraph
+				if c.Type == html.ElementNode && strings.TrimSpace(s.Text()) == "" {
+					continue
+				}
+				break
+			}
+			moved = append([]*goquery.Selection{s}, moved...)
+		}
+	}
+	if len(moved) == 0 {
+		return
+	}
+	for _, s := range moved {
+		s.Remove()
+	}
+	if strings.TrimSpace(clone.Text()) == "" {
+		return
+	}
+	for _, s := range moved {
+		obj := ObjectT{
+			Type: s.Nodes[0].Data,
+			Origin: "MvAddendumToCxt",
+			Selec: s,
+		}
+		src += obj.Fmt()
+		objects += 1
+	}
+	txt = InnerHTML(clone.Nodes[0])
+	return
+}
+
+
+// MkCxt returns the context of the note along with the number of objects it holds
+func (Note NoteType) MkCxt(capillaries []capillary, tStack []*html.Node/*, tRefStack []string*/) (src string, objects int) {
 // in some books headings may contain direct reference to a pic / table,
 // tRefStack should contain these from Preprocess but the corresponding Capillary hasn't been rewritten atm
 	var tRefStack []string 
 	// ignore card(s) not preceed by a heading
-	if loc.IsEmpty() {
+	if Note.Section == nil || Note.Section.Heading == nil {
 		return
 	}
-	for num, fstr := range(m.Config.Functions) {
-		for _, obj := range Note.ObjProvider(num, m.Config.FunctionsScopes[num], fstr, loc, tStack, tRefStack) {
-			main, _ := goquery.OuterHtml(obj.Selec)
-			if !strings.Contains(Note.Txt, main) && !strings.Contains(src, main) {
-				src += obj.Fmt()
+	for _, h := range tStack[1:] {
+		tRefStack = append(tRefStack, Text(h))
+	}
+	// objects already gathered for the other notes of the same heading
+	shared := Note.Section.ShrdObjectSlices
+	for num, c := range capillaries {
+		var count, size int
+		for _, obj := range Note.ObjProvider(num, c, shared, tStack, tRefStack) {
+			if c.MaxObjects > 0 && count == c.MaxObjects {
+				break
+			}
+			main, _ := goquery.OuterHtml(obj.Selec.Eq(obj.PosRefNode))
+			if strings.Contains(Note.Txt, main) || strings.Contains(Note.Context, main) || strings.Contains(src, main) {
+				continue
+			}
+			if !c.captions {
+				obj.Selec = obj.Selec.Eq(obj.PosRefNode)
+				obj.PosRefNode = 0
+				obj.NoCaption = true
 			}
+			str := obj.Fmt()
+			// a big table mustn't crowd out the smaller objects after it
+			if c.MaxBytes > 0 && size+len(str) > c.MaxBytes {
+				continue
+			}
+			src += str
+			count += 1
+			size += len(str)
+			objects += 1
 		}
 	}
 	return
 }
 
 
+// LeadCxt moves the infobox and the images of the lead section, the one before the
+// first heading, out of its text to make its context: capillaries, being relative
+// to headings, have nothing to gather for it. The document is left untouched for
+// the capillaries of the other notes.
+func (Note NoteType) LeadCxt() (txt, src string, objects int) {
+	lead := Note.QNode.Clone()
+	lead.Find("table.infobox, img").Each(func(i int, selec *goquery.Selection) {
+		node := selec.Nodes[0]
+		if node.Data == "img" {
+			// taken along with its infobox
+			if selec.ParentsFiltered("table.infobox").Length() != 0 {
+				return
+			}
+			if p := selec.ParentsFiltered("figure, div.tmulti"); len(p.Nodes) != 0 {
+				selec = p.First()
+			}
+		}
+		// already taken along with a previous img of its figure
+		if selec.Nodes[0].Parent == nil {
+			return
+		}
+		obj := ObjectT{
+			Type: node.Data,
+			Origin: "Overview",
+			Selec: selec,
+		}
+		src += obj.Fmt()
+		selec.Remove()
+		objects += 1
+	})
+	txt = InnerHTML(lead.Nodes[0])
+	return
+}
+
+
 // num = func number (position) in Fn
-func (Note NoteType) ObjProvider(num, scope int, fstr string, loc Location, tStack []*html.Node, tRefStack []string) (ObjectSlice []ObjectT) {
-	if DataRegister[loc].ShrdObjectSlices[num] == nil {
-		// load the func of type "Capillary" and its scope of
+func (Note NoteType) ObjProvider(num int, c capillary, shared [][]ObjectT, tStack []*html.Node, tRefStack []string) (ObjectSlice []ObjectT) {
+	if shared[num] == nil {
+		// get object from the func of type "Capillary", run at its scope of
 		// execution (target heading level)
-		f, ok := mapfunc[fstr]; if ok == false {
-			panic(fmt.Sprintf("Unknown Capillary: \"%s\"\n", fstr))
-		}
-		
-		// get object from that func
-		ObjectSlice = f(Note, tStack, tRefStack, fstr, scope)
+		ObjectSlice = c.run(Note, tStack, tRefStack, c)
 		
 		// cache ObjectSlice in case redesired later
-		if isReusable[fstr] {
-			DataRegister[loc].ShrdObjectSlices[num] = ObjectSlice
+		if isReusable[c.Name] {
+			shared[num] = ObjectSlice
 		}
 	} else {
 		// or get object from cache
-		ObjectSlice = DataRegister[loc].ShrdObjectSlices[num] 
+		ObjectSlice = shared[num] 
 	}
 	return ObjectSlice
 }
 
 
-// TODO Rewrite + if scope=0 search whole doc
-//var reRef = regexp.MustCompile(`(?m)[^\s]+?.*?((?:Abb|Tab)\.\s*?\d*?\.\d*)`)
-func (Note NoteType) UsingRef(tStack []*html.Node, tRefStack []string, fstr string, scope int) (ObjectSlice []ObjectT) {
+// fetch the captioned figures and tables that the text of the note, or its headings, refer to
+// (e.g. "see Fig. 3.2") wherever they are in the section of the heading at scope, 0 being the
+// whole document
+func (Note NoteType) UsingRef(tStack []*html.Node, tRefStack []string, c capillary) (ObjectSlice []ObjectT) {
+	if Note.refs == nil {
+		return
+	}
+	txt := goquery.Selection{Nodes: []*html.Node{Note.QNode.Nodes[0]}}
+	refs := findRefs(Note.refs, append([]string{txt.Text()}, tRefStack...)...)
+	if len(refs) == 0 {
+		return
+	}
+	region := Note.Section.Ancestor(c.Scope)
+	if c.Scope == 0 || region == nil {
+		region = Note.Section.tree.Root
+	}
+	captioned := captionedIn(Note.refs, append([]*Section{region}, region.Descendants()...))
+	for _, r := range refs {
+		if selec, ok := captioned[r]; ok {
+			ObjectSlice = append(ObjectSlice, ObjectT{
+				Type: r.Kind,
+				Origin: c.Name,
+				Scope: c.Scope,
+				Selec: selec,
+			})
+		}
+	}
 	return
 }
 
 // share the img in the higher section level between the to-be-created notes of that section level
-func (Note NoteType) FromSuperior(tStack []*html.Node, _ []string, fstr string, scope int) (ObjectSlice []ObjectT) {
-	return Note.superior(0, tStack, fstr, scope)
+func (Note NoteType) FromSuperior(tStack []*html.Node, _ []string, c capillary) (ObjectSlice []ObjectT) {
+	return Note.superior(0, tStack, c)
 }
 
-func (Note NoteType) FromSuperiorAndDescendants(tStack []*html.Node, _ []string, fstr string, scope int) (ObjectSlice []ObjectT) {
-	return Note.superior(1, tStack, fstr, scope)
+func (Note NoteType) FromSuperiorAndDescendants(tStack []*html.Node, _ []string, c capillary) (ObjectSlice []ObjectT) {
+	return Note.superior(1, tStack, c)
 }
 
-func (Note NoteType) superior(includeDescendants int, tStack []*html.Node, fstr string, scope int) (ObjectSlice []ObjectT) {
+func (Note NoteType) superior(includeDescendants int, tStack []*html.Node, c capillary) (ObjectSlice []ObjectT) {
+	fstr, scope := c.Name, c.Scope
 	logger := zerolog.Nop() // TODO update logger !
 	logger.Debug().
 		Str("fstr", fstr).
@@ -115,7 +311,11 @@ func (Note NoteType) superior(includeDescendants int, tStack []*html.Node, fstr
 	var h *html.Node
 	var x int
 	var s *goquery.Selection
-	if idxMax < scope {
+	if scope == 0 {
+		// the whole document, as for the other capillaries
+		s = Note.QNode.Parent().Children()
+		x = 0
+	} else if idxMax < scope {
 		//logger.Debug().Int("maxed out to", idxMax)
 		//scope = idxMax
 		logger.Debug().
@@ -153,9 +353,11 @@ func (Note NoteType) superior(includeDescendants int, tStack []*html.Node, fstr
 		}		
 		selec.Find("*").Each(func(i int, selec *goquery.Selection) {
 			node := selec.Nodes[0]
-			if node.Data == "img" || node.Data == "table" {
-				if p := selec.ParentsFiltered("figure, div.tmulti"); len(p.Nodes) != 0 {
-					selec = p
+			if c.collect.Match(node) {
+				if c.containers != nil {
+					if p := selec.ParentsMatcher(c.containers); len(p.Nodes) != 0 {
+						selec = p.First()
+					}
 				}
 				ObjectSlice = append(ObjectSlice, ObjectT{
 					Type: node.Data,
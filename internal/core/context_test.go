@@ -0,0 +1,267 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"github.com/tassa-yoniso-manasi-karoto/irgen/internal/meta"
+)
+
+// notesOf cuts the HTML into notes the way process does, before their context is
+// made, keyed by the title of their heading ("" for the lead section)
+func notesOf(t *testing.T, m *meta.Meta, s string) map[string]NoteType {
+	t.Helper()
+	doc := splitDoc(t, s)
+	_, tree := Preprocess(m, doc)
+	captions, err := compileCaptionPatterns(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notes := make(map[string]NoteType)
+	doc.Find("cutpattern").Each(func(_ int, s *goquery.Selection) {
+		section := tree.Of(s.Nodes[0])
+		title := ""
+		if section.Heading != nil {
+			title = Text(section.Heading)
+		}
+		notes[title] = NoteType{
+			QNode: s,
+			Txt: InnerHTML(s.Nodes[0]),
+			Section: section,
+			refs: referencePattern(m),
+			captions: captions,
+		}
+	})
+	return notes
+}
+
+// imgsOf gives the file names of the images of the objects
+func imgsOf(objects []ObjectT) (names []string) {
+	for _, obj := range objects {
+		obj.Selec.Find("img").AddBackFiltered("img").Each(func(_ int, s *goquery.Selection) {
+			names = append(names, imgFile(s))
+		})
+	}
+	return
+}
+
+// the outline of the example of the README, with an image in each section, numbered
+// in the order of the document and each referred to by the text of "least important"
+func scopeDoc(figure func(name string, n int) string) string {
+	return figure("lead", 1) +
+		`<h1>very important</h1>` + figure("very", 2) +
+		`<h2>quite important</h2>` + figure("quite", 3) +
+		`<h3>less important</h3>` + figure("tree", 4) +
+		`<h4>least important</h4><p>Lorem ipsum, see Fig. 1, Fig. 2, Fig. 3, Fig. 4, Fig. 5, Fig. 6 and Fig. 7.</p>` + figure("snake", 5) +
+		`<h4>not important</h4><p>dolor sit amet</p>` + figure("frog", 6) +
+		`<h2>other</h2>` + figure("other", 7)
+}
+
+func TestScope(t *testing.T) {
+	captioned := func(name string, n int) string {
+		return fmt.Sprintf(`<figure><img src="%s.png"><figcaption>Figure %d: the %[1]s</figcaption></figure>`, name, n)
+	}
+	loose := func(name string, n int) string {
+		return fmt.Sprintf(`<p><img src="%s.png"></p><p>Figure %d: the %[1]s</p>`, name, n)
+	}
+	all := "lead very quite tree snake frog other"
+	tests := []struct {
+		function	string
+		scope		int
+		want		string
+	}{
+		// FromSuperior counts the heading of the note as 1
+		{function: "FromSuperior", scope: 0, want: all},
+		{function: "FromSuperior", scope: 1, want: "snake"},
+		{function: "FromSuperior", scope: 2, want: "tree snake frog"},
+		{function: "FromSuperior", scope: 3, want: "quite tree snake frog"},
+		{function: "FromSuperiorAndDescendants", scope: 0, want: all},
+		{function: "FromSuperiorAndDescendants", scope: 1, want: "tree snake frog"},
+		{function: "FromSuperiorAndDescendants", scope: 2, want: "quite tree snake frog"},
+		{function: "FromSuperiorAndDescendants", scope: 3, want: "very quite tree snake frog other"},
+		{function: "FromSuperiorAndDescendants", scope: 4, want: all},
+		{function: "UsingRef", scope: 0, want: all},
+		{function: "UsingRef", scope: 1, want: "tree snake frog"},
+		{function: "UsingRef", scope: 2, want: "quite tree snake frog"},
+		{function: "UsingRef", scope: 3, want: "very quite tree snake frog other"},
+		{function: "UsingRef", scope: 4, want: all},
+		{function: "UsingRef", scope: 5, want: all},
+		{function: "CaptionInspector", scope: 0, want: all},
+		{function: "CaptionInspector", scope: 1, want: "tree snake frog"},
+		{function: "CaptionInspector", scope: 2, want: "quite tree snake frog"},
+		{function: "CaptionInspector", scope: 3, want: "very quite tree snake frog other"},
+		{function: "CaptionInspector", scope: 5, want: all},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s=%d", tt.function, tt.scope), func(t *testing.T) {
+			m := testMeta()
+			m.Config.Functions = []meta.Function{{Name: tt.function, Scope: tt.scope}}
+			capillaries, err := compileCapillaries(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := capillaries[0]
+			// CaptionInspector is after the captions that aren't in a figure
+			figure := captioned
+			if tt.function == "CaptionInspector" {
+				figure = loose
+			}
+			note := notesOf(t, m, scopeDoc(figure))["least important"]
+			got := strings.Join(imgsOf(c.run(note, note.Section.Stack(), note.Section.Path(), c)), " ")
+			got = strings.ReplaceAll(got, ".png", "")
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// inner gives the HTML of the objects of a context
+func inner(t *testing.T, src string) (objects []string) {
+	t.Helper()
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc.Find("section.capillary").Each(func(_ int, s *goquery.Selection) {
+		html, _ := s.Html()
+		objects = append(objects, html)
+	})
+	return
+}
+
+func TestMvAddendumToCxt(t *testing.T) {
+	tests := []struct {
+		name, mode, input	string
+		wantTxt			string
+		want			[]string
+	}{
+		{
+			name: "trailing",
+			mode: "trailing",
+			input: `<p>Lorem</p><p><img src="a.png"/></p><p>ipsum</p><p><img src="b.png"/></p><p></p><p> </p>`,
+			wantTxt: `<p>Lorem</p><p><img src="a.png"/></p><p>ipsum</p><p></p><p> </p>`,
+			want: []string{`<p><img src="b.png"/></p>`},
+		},
+		{
+			name: "trailing figure and table",
+			mode: "trailing",
+			input: `<p>Lorem</p><figure><img src="a.png"/><figcaption>Figure 1: a</figcaption></figure><table><tbody><tr><td>b</td></tr></tbody></table>`,
+			wantTxt: `<p>Lorem</p>`,
+			want: []string{
+				`<figure><img src="a.png"/><figcaption>Figure 1: a</figcaption></figure>`,
+				`<table><tbody><tr><td>b</td></tr></tbody></table>`,
+			},
+		},
+		{
+			name: "nothing else would remain",
+			mode: "trailing",
+			input: `<p><img src="a.png"/></p><p></p>`,
+			wantTxt: `<p><img src="a.png"/></p><p></p>`,
+		},
+		{
+			name: "all",
+			mode: "all",
+			input: `<p>Lorem <img src="a.png"/> ipsum</p><figure><img src="b.png"/><img src="c.png"/></figure><table><tbody><tr><td><img src="d.png"/></td></tr></tbody></table><p>dolor</p>`,
+			wantTxt: `<p>Lorem  ipsum</p><p>dolor</p>`,
+			want: []string{
+				`<img src="a.png"/>`,
+				`<figure><img src="b.png"/><img src="c.png"/></figure>`,
+				`<table><tbody><tr><td><img src="d.png"/></td></tr></tbody></table>`,
+			},
+		},
+		{
+			name: "none",
+			mode: "none",
+			input: `<p>Lorem</p><p><img src="a.png"/></p>`,
+			wantTxt: `<p>Lorem</p><p><img src="a.png"/></p>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testMeta()
+			note := notesOf(t, m, `<h1>A</h1>`+tt.input)["A"]
+			txt, src, objects := note.MvAddendumToCxt(tt.mode)
+			if txt != tt.wantTxt {
+				t.Errorf("got text %q, want %q", txt, tt.wantTxt)
+			}
+			if got := inner(t, src); objects != len(tt.want) || strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got %d objects %q, want %q", objects, got, tt.want)
+			}
+			// the document is left untouched for the capillaries of the other notes
+			if html := InnerHTML(note.QNode.Nodes[0]); html != tt.input {
+				t.Errorf("got document %q, want %q", html, tt.input)
+			}
+		})
+	}
+}
+
+func TestMvAddendumToCxtThenMkCxt(t *testing.T) {
+	m := testMeta()
+	m.Config.Functions = []meta.Function{{Name: "FromSuperiorAndDescendants", Scope: 1}}
+	capillaries, err := compileCapillaries(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	note := notesOf(t, m, `<h1>A</h1><p><img src="a.png"/></p><h2>B</h2><p>Lorem</p><p><img src="b.png"/></p>`)["B"]
+	note.Txt, note.Context, note.Objects = note.MvAddendumToCxt("trailing")
+	src, objects := note.MkCxt(capillaries, note.Section.Stack())
+	// b.png, already moved, isn't added again
+	if got := inner(t, src); objects != 1 || strings.Join(got, "\n") != `<img src="a.png"/>` {
+		t.Errorf("got %d objects %q, want only a.png", objects, got)
+	}
+	if got := inner(t, note.Context); note.Objects != 1 || strings.Join(got, "\n") != `<p><img src="b.png"/></p>` {
+		t.Errorf("got %d objects %q moved, want only b.png", note.Objects, got)
+	}
+}
+
+func TestLeadCxt(t *testing.T) {
+	tests := []struct {
+		name, input	string
+		wantTxt		string
+		want		[]string
+	}{
+		{
+			name: "infobox and images",
+			input: `<table class="infobox"><tbody><tr><td><img src="a.png"/></td></tr></tbody></table><p>The ulna is a bone.</p><p><img src="b.png"/></p>`,
+			wantTxt: `<p>The ulna is a bone.</p><p></p>`,
+			want: []string{
+				`<table class="infobox"><tbody><tr><td><img src="a.png"/></td></tr></tbody></table>`,
+				`<img src="b.png"/>`,
+			},
+		},
+		{
+			name: "figure taken whole",
+			input: `<p>The ulna is a bone.</p><figure><img src="a.png"/><img src="b.png"/><figcaption>the ulna</figcaption></figure>`,
+			wantTxt: `<p>The ulna is a bone.</p>`,
+			want: []string{`<figure><img src="a.png"/><img src="b.png"/><figcaption>the ulna</figcaption></figure>`},
+		},
+		{
+			name: "other tables stay in the text",
+			input: `<p>The ulna is a bone.</p><table><tbody><tr><td>ulna</td></tr></tbody></table>`,
+			wantTxt: `<p>The ulna is a bone.</p><table><tbody><tr><td>ulna</td></tr></tbody></table>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testMeta()
+			note := notesOf(t, m, tt.input+`<h1>A</h1><p>Lorem</p>`)[""]
+			txt, src, objects := note.LeadCxt()
+			if txt != tt.wantTxt {
+				t.Errorf("got text %q, want %q", txt, tt.wantTxt)
+			}
+			if got := inner(t, src); objects != len(tt.want) || strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got %d objects %q, want %q", objects, got, tt.want)
+			}
+			if strings.Count(src, `origin="Overview"`) != objects {
+				t.Errorf("got %q, want the objects to come from the Overview", src)
+			}
+			if html := InnerHTML(note.QNode.Nodes[0]); html != tt.input {
+				t.Errorf("got document %q, want %q", html, tt.input)
+			}
+		})
+	}
+}
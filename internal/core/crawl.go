@@ -0,0 +1,119 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"github.com/tassa-yoniso-manasi-karoto/irgen/internal/meta"
+)
+
+// CrawlType describes how to walk through an online book whose pages are
+// chained by "Next" links.
+type CrawlType struct {
+	Start		string
+	// CSS selector of the link to the next page
+	Next		string
+	MaxPages	int
+	// whether links to other websites must end the crawl
+	SameHost	bool
+}
+
+// ExecuteCrawl fetches the pages one after the other, following the "Next" links
+// from the start page, and processes their contents joined as a single document.
+func ExecuteCrawl(ctx context.Context, m *meta.Meta, title string, crawl CrawlType) (success bool) {
+	start, err := url.Parse(crawl.Start)
+	if err != nil || !isURL(crawl.Start) {
+		m.Log.Error().Str("start", crawl.Start).Msg("the crawl must start from a URL")
+		return
+	}
+	src := SourceType{
+		Media: make(map[string][]byte),
+		Downloads: make(map[string]string),
+	}
+	var remote *ExtractorType
+	prefix := safeFilename(title)
+	if prefix == "" {
+		prefix = safeFilename(start.Host)
+	}
+	visited := make(map[string]bool)
+	for page := crawl.Start; page != ""; {
+		if crawl.MaxPages > 0 && len(src.Chapters) == crawl.MaxPages {
+			m.Log.Warn().Int("max", crawl.MaxPages).Msg("maximum number of pages reached, crawl stopped")
+			break
+		}
+		visited[page] = true
+		m.Log.Info().Msgf("Page %d: %s", len(src.Chapters)+1, page)
+		file, contentType, err := fetch(m, page)
+		if err != nil {
+			m.Log.Error().Err(err).Str("page", page).Msg("couldn't fetch page")
+			return
+		}
+		if file, _, err = toUTF8(file, contentType, m.Config.Charset); err != nil {
+			m.Log.Error().Err(err).Str("page", page).Msg("couldn't decode page")
+			return
+		}
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(file))
+		if err != nil {
+			m.Log.Error().Err(err).Str("page", page).Msg("couldn't parse page")
+			return
+		}
+		// the link must be found before the extraction, it is seldom part of the content
+		next, err := crawl.nextPage(doc, page)
+		if err != nil {
+			m.Log.Warn().Err(err).Str("page", page).Msg("crawl stopped")
+		} else if visited[next] {
+			m.Log.Warn().Str("next", next).Msg("next page already visited, crawl stopped")
+			next = ""
+		}
+		c, x, err := src.chapterFromPage(m, bookChapter{Targ: page}, doc, prefix)
+		if err != nil {
+			m.Log.Error().Err(err).Str("page", page).Msg("couldn't extract page")
+			return
+		}
+		if x != nil && remote == nil {
+			remote = x
+		}
+		src.Chapters = append(src.Chapters, c)
+		page = next
+	}
+	if title == "" {
+		title = src.Chapters[0].Title
+	}
+	m.Log.Info().Int("pages", len(src.Chapters)).Msg("Crawl done")
+	return src.executeJoined(ctx, m, title, remote, src.Join())
+}
+
+// nextPage returns the absolute URL the "Next" link of doc points to, or an empty
+// string on the last page.
+func (crawl CrawlType) nextPage(doc *goquery.Document, page string) (string, error) {
+	link := doc.Find(crawl.Next).First()
+	if link.Length() == 0 {
+		return "", nil
+	}
+	href, ok := link.Attr("href")
+	if !ok {
+		// the selector may target an element wrapping the link
+		href, ok = link.Find("a[href]").First().Attr("href")
+	}
+	if !ok || strings.TrimSpace(href) == "" {
+		return "", nil
+	}
+	base, _ := url.Parse(page)
+	u, err := base.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return "", fmt.Errorf("bad link to next page %q: %w", href, err)
+	}
+	u.Fragment = ""
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("link to next page isn't a web page: %s", u)
+	}
+	if crawl.SameHost && u.Host != base.Host {
+		return "", fmt.Errorf("next page is on another website: %s", u)
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,292 @@
+package core
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+
+	"github.com/tassa-yoniso-manasi-karoto/irgen/internal/meta"
+)
+
+var epub = ReaderType{
+	Name: "EPUB",
+	Exts: []string{".epub"},
+	Read: readEPUB,
+}
+
+type epubContainer struct {
+	Rootfiles []struct {
+		FullPath string `xml:"full-path,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+type epubPackage struct {
+	Title	string `xml:"metadata>title"`
+	Items	[]struct {
+		ID		string `xml:"id,attr"`
+		Href		string `xml:"href,attr"`
+		MediaType	string `xml:"media-type,attr"`
+		Properties	string `xml:"properties,attr"`
+	} `xml:"manifest>item"`
+	Spine struct {
+		Toc	string `xml:"toc,attr"`
+		Itemrefs []struct {
+			IDRef	string `xml:"idref,attr"`
+			Linear	string `xml:"linear,attr"`
+		} `xml:"itemref"`
+	} `xml:"spine"`
+}
+
+type ncxNavPoint struct {
+	Label	string `xml:"navLabel>text"`
+	Content	struct {
+		Src string `xml:"src,attr"`
+	} `xml:"content"`
+	NavPoints []ncxNavPoint `xml:"navPoint"`
+}
+
+type ncxDoc struct {
+	NavPoints []ncxNavPoint `xml:"navMap>navPoint"`
+}
+
+// entry of the table of contents, Depth is 1 for the top level
+type tocEntry struct {
+	Label, File, Fragment string
+	Depth int
+}
+
+var (
+	// XHTML allows <a id="p12"/> but the HTML parser would take it as an
+	// opening tag swallowing everything that follows
+	reSelfClosing = regexp.MustCompile(`<([a-zA-Z][a-zA-Z0-9:-]*)((?:\s[^<>]*?)?)/>`)
+	voidElements = []string{"area", "base", "br", "col", "embed", "hr", "img", "input", "link", "meta", "source", "track", "wbr"}
+)
+
+func readEPUB(m *meta.Meta, p string) (src SourceType, err error) {
+	zr, err := zip.OpenReader(p)
+	if err != nil {
+		return src, fmt.Errorf("couldn't open EPUB: %w", err)
+	}
+	defer zr.Close()
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	var container epubContainer
+	if err = unmarshalZipXML(files, "META-INF/container.xml", &container); err != nil {
+		return
+	}
+	if len(container.Rootfiles) == 0 {
+		return src, fmt.Errorf("no rootfile declared in META-INF/container.xml")
+	}
+	opfPath := container.Rootfiles[0].FullPath
+	var pkg epubPackage
+	if err = unmarshalZipXML(files, opfPath, &pkg); err != nil {
+		return
+	}
+	opfDir := path.Dir(opfPath)
+	hrefs := make(map[string]string)
+	var toc []tocEntry
+	for _, item := range pkg.Items {
+		hrefs[item.ID] = resolveZipPath(opfDir, item.Href)
+		if contains(strings.Fields(item.Properties), "nav") {
+			toc = readNavTOC(files, resolveZipPath(opfDir, item.Href))
+		}
+	}
+	// EPUB2 fallback
+	if len(toc) == 0 && pkg.Spine.Toc != "" {
+		var ncx ncxDoc
+		ncxPath := hrefs[pkg.Spine.Toc]
+		if err := unmarshalZipXML(files, ncxPath, &ncx); err != nil {
+			m.Log.Warn().Err(err).Msg("couldn't read the NCX table of contents of the EPUB")
+		}
+		toc = flattenNCX(ncx.NavPoints, path.Dir(ncxPath), 1)
+	}
+	m.Log.Debug().
+		Str("title", pkg.Title).
+		Int("spine", len(pkg.Spine.Itemrefs)).
+		Int("toc", len(toc)).
+		Msg("EPUB package read")
+
+	prefix := strings.TrimSuffix(filepath.Base(p), filepath.Ext(p))
+	src.Media = make(map[string][]byte)
+	for _, itemref := range pkg.Spine.Itemrefs {
+		if itemref.Linear == "no" {
+			continue
+		}
+		chapterPath, ok := hrefs[itemref.IDRef]
+		if !ok {
+			continue
+		}
+		data, err := readZipFile(files, chapterPath)
+		if err != nil {
+			m.Log.Error().Err(err).Str("chapter", chapterPath).Msg("couldn't read EPUB chapter")
+			continue
+		}
+		data = reSelfClosing.ReplaceAllFunc(data, func(b []byte) []byte {
+			sub := reSelfClosing.FindSubmatch(b)
+			if contains(voidElements, strings.ToLower(string(sub[1]))) {
+				return b
+			}
+			return []byte(fmt.Sprintf("<%s%s></%s>", sub[1], sub[2], sub[1]))
+		})
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(data))
+		if err != nil {
+			m.Log.Error().Err(err).Str("chapter", chapterPath).Msg("couldn't parse EPUB chapter")
+			continue
+		}
+		var entries []tocEntry
+		for _, entry := range toc {
+			if entry.File == chapterPath {
+				entries = append(entries, entry)
+			}
+		}
+		if findHeadings(doc.Selection).Length() == 0 {
+			headingsFromTOC(doc, entries)
+		}
+		chapterDir := path.Dir(chapterPath)
+		doc.Find("img, image").Each(func(i int, s *goquery.Selection) {
+			attr := "src"
+			// <image> of inline SVG, goquery ignores the xlink: namespace
+			if goquery.NodeName(s) == "image" {
+				attr = "href"
+			}
+			ref, found := s.Attr(attr)
+			if !found || strings.Contains(ref, "://") || strings.HasPrefix(ref, "data:") {
+				return
+			}
+			mediaPath := resolveZipPath(chapterDir, ref)
+			img, err := readZipFile(files, mediaPath)
+			if err != nil {
+				m.Log.Warn().Err(err).Str("src", ref).Msg("img referenced by EPUB chapter not found in package")
+				return
+			}
+			filename := mediaFilename(prefix, strings.TrimPrefix(mediaPath, opfDir+"/"))
+			src.Media[filename] = img
+			s.SetAttr(attr, filename)
+		})
+		chapter := ChapterType{Title: strings.TrimSpace(doc.Find("title").First().Text())}
+		if len(entries) != 0 {
+			chapter.Title = entries[0].Label
+		}
+		h, err := doc.Html()
+		if err != nil {
+			continue
+		}
+		chapter.HTML = []byte(h)
+		src.Chapters = append(src.Chapters, chapter)
+	}
+	if len(src.Chapters) == 0 {
+		return src, fmt.Errorf("no readable chapter found in the spine of the EPUB")
+	}
+	return
+}
+
+// headingsFromTOC inserts the headings that a chapter lacks by using the labels of the
+// TOC entries pointing into it. Their depth in the TOC gives the level of the heading.
+func headingsFromTOC(doc *goquery.Document, entries []tocEntry) {
+	// those without a fragment all go at the top of the chapter, in the order of the TOC
+	var top string
+	for _, entry := range entries {
+		level := entry.Depth
+		heading := fmt.Sprintf("<h%d>%s</h%d>", level, html.EscapeString(entry.Label), level)
+		if entry.Fragment != "" {
+			if s := doc.Find("[id='" + entry.Fragment + "']").First(); s.Length() != 0 {
+				s.BeforeHtml(heading)
+				continue
+			}
+		}
+		top += heading
+	}
+	if top != "" {
+		doc.Find("body").PrependHtml(top)
+	}
+}
+
+func readNavTOC(files map[string]*zip.File, navPath string) (toc []tocEntry) {
+	data, err := readZipFile(files, navPath)
+	if err != nil {
+		return
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	nav := doc.Find("nav").FilterFunction(func(i int, s *goquery.Selection) bool {
+		t, _ := s.Attr("epub:type")
+		return t == "toc"
+	}).First()
+	if nav.Length() == 0 {
+		nav = doc.Find("nav").First()
+	}
+	navDir := path.Dir(navPath)
+	nav.Find("li > a, li > span").Each(func(i int, s *goquery.Selection) {
+		href, _ := s.Attr("href")
+		file, fragment, _ := strings.Cut(href, "#")
+		if file != "" {
+			file = resolveZipPath(navDir, file)
+		}
+		toc = append(toc, tocEntry{
+			Label: strings.Join(strings.Fields(s.Text()), " "),
+			File: file,
+			Fragment: fragment,
+			Depth: s.ParentsUntilSelection(nav).Filter("ol").Length(),
+		})
+	})
+	return
+}
+
+func flattenNCX(points []ncxNavPoint, ncxDir string, depth int) (toc []tocEntry) {
+	for _, point := range points {
+		file, fragment, _ := strings.Cut(point.Content.Src, "#")
+		toc = append(toc, tocEntry{
+			Label: strings.Join(strings.Fields(point.Label), " "),
+			File: resolveZipPath(ncxDir, file),
+			Fragment: fragment,
+			Depth: depth,
+		})
+		toc = append(toc, flattenNCX(point.NavPoints, ncxDir, depth+1)...)
+	}
+	return
+}
+
+func resolveZipPath(dir, ref string) string {
+	if unescaped, err := url.PathUnescape(ref); err == nil {
+		ref = unescaped
+	}
+	return strings.TrimPrefix(path.Join(dir, ref), "/")
+}
+
+func readZipFile(files map[string]*zip.File, name string) ([]byte, error) {
+	f, ok := files[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: not found in archive", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+func unmarshalZipXML(files map[string]*zip.File, name string, v any) error {
+	data, err := readZipFile(files, name)
+	if err != nil {
+		return err
+	}
+	if err := xml.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const (
+	epubContainerXML = `<?xml version="1.0"?><container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">` +
+		`<rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles></container>`
+	epubNCX = `<?xml version="1.0"?><ncx xmlns="http://www.daisy.org/z3986/2005/ncx/"><navMap>` +
+		`<navPoint><navLabel><text>Part One</text></navLabel><content src="text/ch1.xhtml"/>` +
+		`<navPoint><navLabel><text>Section</text></navLabel><content src="text/ch1.xhtml#s1"/></navPoint>` +
+		`</navPoint>` +
+		`<navPoint><navLabel><text>Part Two</text></navLabel><content src="text/ch2.xhtml"/></navPoint>` +
+		`</navMap></ncx>`
+	epubNav = `<html xmlns:epub="http://www.idpf.org/2007/ops"><body>` +
+		`<nav epub:type="landmarks"><ol><li><a href="text/ch2.xhtml">Start</a></li></ol></nav>` +
+		`<nav epub:type="toc"><ol>` +
+		`<li><a href="text/ch1.xhtml">Part One</a><ol><li><a href="text/ch1.xhtml#s1">Section</a></li></ol></li>` +
+		`<li><a href="text/ch2.xhtml">Part Two</a></li>` +
+		`</ol></nav></body></html>`
+	epubCh1 = `<html><head><title>ch1</title></head><body><p>intro</p><a id="p1"/><p id="s1">section text</p></body></html>`
+	epubCh2 = `<html><head><title>ch2</title></head><body><p><img src="../images/pic%201.png"/></p>` +
+		`<svg><image href="../images/pic%201.png"/></svg></body></html>`
+)
+
+// epubOPF declares the chapters in the manifest, the nav document if nav, and the spine
+func epubOPF(nav bool, spine ...string) string {
+	s := `<?xml version="1.0"?><package xmlns="http://www.idpf.org/2007/opf"><metadata><title>Book</title></metadata><manifest>` +
+		`<item id="ch1" href="text/ch1.xhtml" media-type="application/xhtml+xml"/>` +
+		`<item id="ch2" href="text/ch2.xhtml" media-type="application/xhtml+xml"/>` +
+		`<item id="notes" href="text/notes.xhtml" media-type="application/xhtml+xml"/>` +
+		`<item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>`
+	if nav {
+		s += `<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>`
+	}
+	s += `</manifest><spine toc="ncx">`
+	for _, id := range spine {
+		s += `<itemref idref="` + id + `"/>`
+	}
+	return s + `<itemref idref="notes" linear="no"/></spine></package>`
+}
+
+func TestReadEPUB(t *testing.T) {
+	tests := []struct {
+		name		string
+		nav		bool
+		spine		[]string
+		wantTitles	string
+	}{
+		{name: "NCX", spine: []string{"ch1", "ch2"}, wantTitles: "Part One|Part Two"},
+		{name: "nav", nav: true, spine: []string{"ch1", "ch2"}, wantTitles: "Part One|Part Two"},
+		{name: "spine order", nav: true, spine: []string{"ch2", "ch1"}, wantTitles: "Part Two|Part One"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{
+				"mimetype":			"application/epub+zip",
+				"META-INF/container.xml":	epubContainerXML,
+				"OEBPS/content.opf":		epubOPF(tt.nav, tt.spine...),
+				"OEBPS/toc.ncx":		epubNCX,
+				"OEBPS/nav.xhtml":		epubNav,
+				"OEBPS/text/ch1.xhtml":		epubCh1,
+				"OEBPS/text/ch2.xhtml":		epubCh2,
+				"OEBPS/text/notes.xhtml":	`<html><body><p>notes</p></body></html>`,
+				"OEBPS/images/pic 1.png":	"png",
+			}
+			src, err := readEPUB(testMeta(), writeZip(t, "book.epub", files))
+			if err != nil {
+				t.Fatal(err)
+			}
+			chapters := make(map[string]string)
+			var titles []string
+			for _, c := range src.Chapters {
+				titles = append(titles, c.Title)
+				chapters[c.Title] = string(c.HTML)
+			}
+			if strings.Join(titles, "|") != tt.wantTitles {
+				t.Fatalf("got chapters %q, want %s", titles, tt.wantTitles)
+			}
+			// the headings the chapters lack are made from the TOC, its depth giving their level
+			for _, s := range []string{`<body><h1>Part One</h1><p>intro</p>`, `<a id="p1"></a><h2>Section</h2><p id="s1">`} {
+				if !strings.Contains(chapters["Part One"], s) {
+					t.Errorf("%q not found in\n%s", s, chapters["Part One"])
+				}
+			}
+			// paths of the images are relative to the chapter, their names to the OPF
+			const filename = "book_images_pic_1.png"
+			if _, ok := src.Media[filename]; !ok || len(src.Media) != 1 {
+				t.Errorf("got media %v, want only %s", src.Media, filename)
+			}
+			for _, s := range []string{`<img src="` + filename + `"/>`, `<image href="` + filename + `">`} {
+				if !strings.Contains(chapters["Part Two"], s) {
+					t.Errorf("%q not found in\n%s", s, chapters["Part Two"])
+				}
+			}
+		})
+	}
+}
+
+func TestHeadingsFromTOC(t *testing.T) {
+	tests := []struct {
+		name, body	string
+		entries		[]tocEntry
+		want		string
+	}{
+		{
+			name: "fragment-less entries go at the top in the order of the TOC",
+			body: `<p>text</p>`,
+			entries: []tocEntry{{Label: "Part", Depth: 1}, {Label: "Chapter", Depth: 2}, {Label: "Intro", Depth: 3}},
+			want: `<h1>Part</h1><h2>Chapter</h2><h3>Intro</h3><p>text</p>`,
+		},
+		{
+			name: "fragments",
+			body: `<p>a</p><div id="x"><p>b</p></div><p id="y">c</p>`,
+			entries: []tocEntry{{Label: "Top", Depth: 1}, {Label: "X", Fragment: "x", Depth: 2}, {Label: "Y", Fragment: "y", Depth: 2}},
+			want: `<h1>Top</h1><p>a</p><h2>X</h2><div id="x"><p>b</p></div><h2>Y</h2><p id="y">c</p>`,
+		},
+		{
+			name: "fragment not found",
+			body: `<p>a</p>`,
+			entries: []tocEntry{{Label: "Lost", Fragment: "nowhere", Depth: 2}},
+			want: `<h2>Lost</h2><p>a</p>`,
+		},
+		{
+			name: "labels are escaped",
+			body: `<p>a</p>`,
+			entries: []tocEntry{{Label: "A <b> & C", Depth: 1}},
+			want: `<h1>A &lt;b&gt; &amp; C</h1><p>a</p>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := goquery.NewDocumentFromReader(strings.NewReader("<html><body>" + tt.body + "</body></html>"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			headingsFromTOC(doc, tt.entries)
+			if got, _ := doc.Find("body").Html(); got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"net/url"
 	"context"
 	"slices"
+	"sync"
 	
 	"github.com/PuerkitoBio/goquery"
 	"github.com/gookit/color"
@@ -48,6 +49,8 @@ type ThumbnailType struct {
 var (
 	extractors = []ExtractorType{wiki}
 	SupportedIMGExt = []string{".jpg", ".jpeg", ".png", ".tif", ".tiff", ".gif", ".svg", ".webp", ".avif"}
+	// file description page → preferred resolution of the image, shared by the jobs
+	wikiHiRes sync.Map
 )
 
 var local = ExtractorType{
@@ -195,8 +198,10 @@ var wiki = ExtractorType{
 
 
 
-func (Extractor ExtractorType) TakeImgAlong(ctx context.Context, m *meta.Meta, n *goquery.Selection) {
+func (Extractor ExtractorType) TakeImgAlong(ctx context.Context, m *meta.Meta, n *goquery.Selection, articleName string) {
 	if Extractor.Name == "local"  {
+		// archives made by SingleFile inline their images as data: URIs
+		importMedia(m, extractDataURIs(n, articleName))
 		origDir := filepath.Dir(m.Targ)
 		files, _ := ioutil.ReadDir(origDir)
 		var total int
@@ -205,6 +210,10 @@ func (Extractor ExtractorType) TakeImgAlong(ctx context.Context, m *meta.Meta, n
 			destPath := filepath.Join(m.Config.CollectionMedia, file.Name())
 			_, err := os.Stat(destPath)
 			if errors.Is(err, os.ErrNotExist) && slices.Contains(SupportedIMGExt, filepath.Ext(origPath)) {
+				if m.Config.DryRun {
+					total += 1
+					continue
+				}
 				origFile, err := os.Open(origPath)
 				if err != nil {
 					m.Log.Error().Err(err).Str("origPath", origPath).Msg("can't read img to copy")
@@ -223,7 +232,11 @@ func (Extractor ExtractorType) TakeImgAlong(ctx context.Context, m *meta.Meta, n
 				total += 1
 			}
 		}
-		m.Log.Info().Msg(fmt.Sprint(total, " images copied."))
+		if m.Config.DryRun {
+			m.Log.Info().Msg(fmt.Sprint("dry run: ", total, " images not copied."))
+		} else {
+			m.Log.Info().Msg(fmt.Sprint(total, " images copied."))
+		}
 	} else {
 		Extractor.IMGProcessor(ctx, m, n)
 	}
@@ -231,6 +244,15 @@ func (Extractor ExtractorType) TakeImgAlong(ctx context.Context, m *meta.Meta, n
 
 
 func wikiPrefForHiRes(m *meta.Meta, href string) (wanted string) {
+	// images shared by the articles imported in a same run are only looked up once
+	if wanted, ok := wikiHiRes.Load(href); ok {
+		return wanted.(string)
+	}
+	defer func() {
+		if wanted != "" {
+			wikiHiRes.Store(href, wanted)
+		}
+	}()
 	resp, err := http.Get(href)
 	if err != nil {
 		m.Log.Error().Err(err).Str("href", href).Msg("error during GET request to img")
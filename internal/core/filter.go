@@ -0,0 +1,112 @@
+package core
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/tassa-yoniso-manasi-karoto/irgen/internal/meta"
+)
+
+// Sections are included or excluded according to the path of their headings, from the
+// top level down, e.g. "Anatomy/Bones/Ulna". Patterns are globs in which * matches
+// within a heading and ** any number of headings ("Anatomy/**", "*/Etymology"), or,
+// if prefixed with "re:", regexes matched against the path ("re:(?i)/(etymology|étymologie)$").
+// Globs are case-insensitive.
+//
+// A section matched is matched along with its subsections. When there are patterns to
+// include, only the sections they match are kept; the patterns to exclude then have the
+// last word.
+
+type sectionFilter struct {
+	include, exclude []pathPattern
+}
+
+type pathPattern struct {
+	glob	[]string
+	re	*regexp.Regexp
+}
+
+// newSectionFilter compiles the patterns of the config, those common to all extractors
+// and those specific to the extractor named name.
+func newSectionFilter(m *meta.Meta, name string) (f sectionFilter, err error) {
+	include := slices.Concat(m.Config.Include, m.Config.Filters[name].Include)
+	exclude := slices.Concat(m.Config.Exclude, m.Config.Filters[name].Exclude)
+	if f.include, err = compilePatterns(include); err != nil {
+		return
+	}
+	f.exclude, err = compilePatterns(exclude)
+	return
+}
+
+func compilePatterns(patterns []string) (compiled []pathPattern, err error) {
+	for _, pattern := range patterns {
+		if expr, ok := strings.CutPrefix(pattern, "re:"); ok {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid section pattern %q: %w", pattern, err)
+			}
+			compiled = append(compiled, pathPattern{re: re})
+			continue
+		}
+		glob := strings.Split(strings.ToLower(strings.Trim(pattern, "/")), "/")
+		for _, segment := range glob {
+			if _, err := path.Match(segment, ""); err != nil {
+				return nil, fmt.Errorf("invalid section pattern %q: %w", pattern, err)
+			}
+		}
+		compiled = append(compiled, pathPattern{glob: glob})
+	}
+	return
+}
+
+// keep tells whether the notes of the section are to be made
+func (f sectionFilter) keep(s *Section) bool {
+	if len(f.include) == 0 && len(f.exclude) == 0 {
+		return true
+	}
+	titles := s.Path()
+	if len(f.include) != 0 && !matchesAny(f.include, titles) {
+		return false
+	}
+	return !matchesAny(f.exclude, titles)
+}
+
+// matchesAny tells whether a pattern matches the path of titles or of one of its ancestors
+func matchesAny(patterns []pathPattern, titles []string) bool {
+	for i := len(titles); i > 0; i-- {
+		for _, p := range patterns {
+			if p.match(titles[:i]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p pathPattern) match(titles []string) bool {
+	if p.re != nil {
+		return p.re.MatchString(strings.Join(titles, "/"))
+	}
+	lower := make([]string, len(titles))
+	for i, title := range titles {
+		lower[i] = strings.ToLower(title)
+	}
+	return matchGlob(p.glob, lower)
+}
+
+func matchGlob(glob, titles []string) bool {
+	if len(glob) == 0 {
+		return len(titles) == 0
+	}
+	if glob[0] == "**" {
+		return matchGlob(glob[1:], titles) || (len(titles) != 0 && matchGlob(glob, titles[1:]))
+	}
+	if len(titles) == 0 {
+		return false
+	}
+	ok, _ := path.Match(glob[0], titles[0])
+	return ok && matchGlob(glob[1:], titles[1:])
+}
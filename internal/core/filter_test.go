@@ -0,0 +1,128 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tassa-yoniso-manasi-karoto/irgen/internal/meta"
+)
+
+func TestCompilePatterns(t *testing.T) {
+	tests := []struct {
+		pattern	string
+		wantErr	bool
+	}{
+		{pattern: "Anatomy/**"},
+		{pattern: "/*/Etymology/"},
+		{pattern: "re:(?i)/(etymology|étymologie)$"},
+		{pattern: "re:(unclosed", wantErr: true},
+		{pattern: "Bones/[a-", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			_, err := compilePatterns([]string{tt.pattern})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want one: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMatchesAny(t *testing.T) {
+	tests := []struct {
+		pattern, path	string
+		want		bool
+	}{
+		{"Anatomy", "Anatomy", true},
+		{"anatomy", "Anatomy/Bones/Ulna", true},
+		{"Anatomy", "Physiology", false},
+		{"Anatomy/*", "Anatomy/Bones", true},
+		{"Anatomy/*", "Anatomy", false},
+		{"*/Etymology", "Cat/Etymology", true},
+		{"*/Etymology", "Etymology", false},
+		{"*/Etymology", "A/B/Etymology", false},
+		{"**/Etymology", "A/B/Etymology", true},
+		{"**/Etymology", "Etymology", true},
+		{"Anatomy/**/Ulna", "Anatomy/Ulna", true},
+		{"Anatomy/**/Ulna", "Anatomy/Bones/Arm/Ulna/Shaft", true},
+		{"Bo*/Ul?a", "Anatomy/Bones/Ulna", false},
+		{"re:/Ulna$", "Anatomy/Bones/Ulna", true},
+		{"re:/Ulna$", "Anatomy/Bones/Ulna/Shaft", true},
+		{"re:^Ulna", "Anatomy/Bones/Ulna", false},
+		{"re:ulna", "Anatomy/Bones/Ulna", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.path, func(t *testing.T) {
+			patterns, err := compilePatterns([]string{tt.pattern})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := matchesAny(patterns, strings.Split(tt.path, "/")); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSectionFilterKeep(t *testing.T) {
+	const doc = `<p>lead</p>` +
+		`<h1>Anatomy</h1><p>a</p><h2>Bones</h2><p>b</p><h2>Etymology</h2><p>c</p>` +
+		`<h1>Physiology</h1><p>d</p><h2>Etymology</h2><p>e</p>`
+	tests := []struct {
+		name			string
+		include, exclude	[]string
+		filters			map[string]meta.SectionFilter
+		want			string
+	}{
+		{
+			name: "no pattern",
+			want: "lead|a|b|c|d|e",
+		},
+		{
+			name: "include",
+			include: []string{"Anatomy"},
+			want: "a|b|c",
+		},
+		{
+			name: "exclude",
+			exclude: []string{"*/Etymology"},
+			want: "lead|a|b|d",
+		},
+		{
+			name: "exclude has the last word",
+			include: []string{"Anatomy"},
+			exclude: []string{"**/Etymology"},
+			want: "a|b",
+		},
+		{
+			name: "patterns of the extractor",
+			include: []string{"Physiology"},
+			filters: map[string]meta.SectionFilter{
+				"local": {Include: []string{"Anatomy/Bones"}},
+				"Wikipedia": {Exclude: []string{"Physiology"}},
+			},
+			want: "b|d|e",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testMeta()
+			m.Config.Include, m.Config.Exclude, m.Config.Filters = tt.include, tt.exclude, tt.filters
+			f, err := newSectionFilter(m, "local")
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := splitDoc(t, doc)
+			_, tree := Preprocess(m, d)
+			var kept []string
+			for _, n := range d.Find("body > cutpattern").Nodes {
+				if f.keep(tree.Of(n)) {
+					kept = append(kept, blockText(n))
+				}
+			}
+			if got := strings.Join(kept, "|"); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"github.com/tassa-yoniso-manasi-karoto/irgen/internal/meta"
+	"github.com/tassa-yoniso-manasi-karoto/irgen/internal/common"
+)
+
+/*
+Documents exported from PDFs or word processors often have no heading tags at all,
+their titles being mere paragraphs that only look like titles. When the content has
+no <h1>-<h6>, such paragraphs are promoted to headings using the rules enabled in
+the config, in this order:
+	aria		role="heading" with aria-level
+	class		a class matching HeadingClasses, e.g. "Heading2"
+	fontsize	a font-size in the inline style bigger than that of the text
+	bold		a short paragraph that is entirely bold
+*/
+
+type inferredHeading struct {
+	Node	*html.Node
+	Level	int
+	Rule	string
+}
+
+var (
+	reFontSize = regexp.MustCompile(`(?i)font-size\s*:\s*([0-9.]+)\s*(pt|px|em|rem|%)`)
+	reFontWeight = regexp.MustCompile(`(?i)font-weight\s*:\s*(bold|bolder|[6-9]00)`)
+	inferable = "p, div, span, font"
+)
+
+const (
+	// a paragraph much longer than that is no title
+	maxHeadingLen = 150
+	// the font must be that much bigger than the text's to make a heading
+	fontSizeRatio = 1.15
+)
+
+func inferHeadings(m *meta.Meta, content *goquery.Selection) (found []inferredHeading) {
+	if len(m.Config.HeadingInference) == 0 || findHeadings(content).Length() != 0 {
+		return
+	}
+	reClass, err := regexp.Compile(m.Config.HeadingClasses)
+	if err != nil {
+		m.Log.Error().Err(err).Str("headingClasses", m.Config.HeadingClasses).Msg("invalid pattern for heading classes")
+		reClass = nil
+	}
+	done := make(map[*html.Node]bool)
+	promote := func(s *goquery.Selection, level int, rule string) {
+		n := s.Nodes[0]
+		// only the outermost element of a title is promoted
+		if done[n] {
+			return
+		}
+		for p := n.Parent; p != nil; p = p.Parent {
+			if done[p] || isHeading(p) {
+				return
+			}
+		}
+		level = max(level, 1)
+		n.Data = fmt.Sprint("h", level)
+		n.DataAtom = atom.Lookup([]byte(n.Data))
+		done[n] = true
+		found = append(found, inferredHeading{n, level, rule})
+	}
+	deepest := 0
+	for _, rule := range m.Config.HeadingInference {
+		switch rule {
+		case "aria":
+			content.Find("[role=heading]").Each(func(i int, s *goquery.Selection) {
+				// 2 is the default level of the ARIA specification
+				level := 2
+				if x, err := strconv.Atoi(s.AttrOr("aria-level", "")); err == nil {
+					level = x
+				}
+				promote(s, level, rule)
+				deepest = max(deepest, level)
+			})
+		case "class":
+			if reClass == nil {
+				continue
+			}
+			content.Find(inferable).Each(func(i int, s *goquery.Selection) {
+				for _, class := range strings.Fields(s.AttrOr("class", "")) {
+					sub := reClass.FindStringSubmatch(class)
+					if sub == nil || !isShortText(s) {
+						continue
+					}
+					level := 1
+					for _, group := range sub[1:] {
+						if x, err := strconv.Atoi(group); err == nil {
+							level = x
+							break
+						}
+					}
+					promote(s, level, rule)
+					deepest = max(deepest, level)
+					return
+				}
+			})
+		case "fontsize":
+			levels := fontSizeLevels(content)
+			content.Find(inferable).Each(func(i int, s *goquery.Selection) {
+				if level, ok := levels[s.Nodes[0]]; ok {
+					promote(s, level, rule)
+					deepest = max(deepest, level)
+				}
+			})
+		case "bold":
+			level := deepest + 1
+			content.Find("p, div").Each(func(i int, s *goquery.Selection) {
+				if isShortText(s) && isAllBold(s) {
+					promote(s, level, rule)
+				}
+			})
+		default:
+			m.Log.Warn().Str("rule", rule).Msg("unknown heading inference rule")
+		}
+	}
+	if len(found) != 0 {
+		m.Log.Info().Int("headings", len(found)).Msg("Headings inferred from the typography")
+	}
+	return
+}
+
+// previewHeadings logs the outline of content, telling which headings were inferred and how.
+func previewHeadings(m *meta.Meta, content *goquery.Selection, inferred []inferredHeading) {
+	rules := make(map[*html.Node]string)
+	for _, h := range inferred {
+		rules[h.Node] = h.Rule
+	}
+	m.Log.Info().Msg("Outline of the document:")
+	findHeadings(content).Each(func(i int, s *goquery.Selection) {
+		n := s.Nodes[0]
+		rule, ok := rules[n]
+		if !ok {
+			rule = "markup"
+		}
+		x, _ := strconv.Atoi(n.Data[1:])
+		// the wiki extractor shifts the headings up, down to <h0>
+		m.Log.Info().Str("by", rule).Msgf("%s%s %s", strings.Repeat("  ", max(x-1, 0)), n.Data, common.StringCapLen(Text(n), 80))
+	})
+}
+
+func isShortText(s *goquery.Selection) bool {
+	text := strings.TrimSpace(s.Text())
+	return text != "" && len([]rune(text)) <= maxHeadingLen && s.Find("img, table, ul, ol, p").Length() == 0
+}
+
+// isAllBold tells whether every bit of text of s is inside bold markup
+func isAllBold(s *goquery.Selection) bool {
+	if isBold(s.Nodes[0]) {
+		return true
+	}
+	allBold := true
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil && allBold; c = c.NextSibling {
+			switch {
+			case c.Type == html.TextNode:
+				if strings.TrimSpace(c.Data) != "" {
+					allBold = false
+				}
+			case c.Type == html.ElementNode && !isBold(c):
+				walk(c)
+			}
+		}
+	}
+	walk(s.Nodes[0])
+	return allBold
+}
+
+func isBold(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	if n.Data == "b" || n.Data == "strong" {
+		return true
+	}
+	for _, a := range n.Attr {
+		if a.Key == "style" && reFontWeight.MatchString(a.Val) {
+			return true
+		}
+	}
+	return false
+}
+
+// fontSizeLevels gives a heading level to the elements whose text is written bigger than
+// the bulk of the text, the biggest font being h1. The size of the bulk is that of most
+// characters, unsized text counting as 12pt.
+func fontSizeLevels(content *goquery.Selection) map[*html.Node]int {
+	sizes := make(map[*html.Node]float64)
+	weights := make(map[float64]int)
+	content.Find("p, div, li, td").Each(func(i int, s *goquery.Selection) {
+		if s.Find("p, div, li, td").Length() != 0 {
+			return
+		}
+		text := strings.TrimSpace(s.Text())
+		size := fontSizeOf(s)
+		weights[size] += len([]rune(text))
+		if size != 0 {
+			sizes[s.Nodes[0]] = size
+		}
+	})
+	body, most := 12.0, 0
+	for size, weight := range weights {
+		if weight > most {
+			body, most = size, weight
+		}
+	}
+	if body == 0 {
+		body = 12
+	}
+	var bigger []float64
+	for n, size := range sizes {
+		s := goquery.Selection{Nodes: []*html.Node{n}}
+		if size < body*fontSizeRatio || !isShortText(&s) {
+			delete(sizes, n)
+		} else if !slices.Contains(bigger, size) {
+			bigger = append(bigger, size)
+		}
+	}
+	slices.Sort(bigger)
+	slices.Reverse(bigger)
+	levels := make(map[*html.Node]int)
+	for n, size := range sizes {
+		levels[n] = min(slices.Index(bigger, size)+1, 6)
+	}
+	return levels
+}
+
+// fontSizeOf returns the font size, in points, of s or of the descendant holding all
+// its text, or 0 if none is set
+func fontSizeOf(s *goquery.Selection) float64 {
+	text := strings.TrimSpace(s.Text())
+	var size float64
+	s.Find("*").AddBack().EachWithBreak(func(i int, e *goquery.Selection) bool {
+		sub := reFontSize.FindStringSubmatch(e.AttrOr("style", ""))
+		if sub == nil || strings.TrimSpace(e.Text()) != text {
+			return true
+		}
+		x, _ := strconv.ParseFloat(sub[1], 64)
+		switch strings.ToLower(sub[2]) {
+		case "px":
+			x *= 0.75
+		case "em", "rem":
+			x *= 12
+		case "%":
+			x *= 12.0 / 100
+		}
+		size = x
+		return false
+	})
+	return size
+}
@@ -0,0 +1,140 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/rs/zerolog"
+)
+
+func bodyOf(t *testing.T, s string) *goquery.Selection {
+	t.Helper()
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader("<html><body>" + s + "</body></html>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc.Find("body")
+}
+
+const bodyText = `<p>Some text of the body long enough to be the bulk of the characters of the document.</p>`
+
+func TestInferHeadings(t *testing.T) {
+	tests := []struct {
+		name, input	string
+		rules		[]string
+		want		[]string // tag, text and rule of the inferred headings
+	}{
+		{
+			name: "headings in the markup disable inference",
+			input: `<h2>Real</h2><p class="Heading1">Fake</p>`,
+			want: nil,
+		},
+		{
+			name: "aria",
+			input: `<div role="heading" aria-level="3">Deep</div><div role="heading">Default</div>`,
+			want: []string{"h3 Deep aria", "h2 Default aria"},
+		},
+		{
+			name: "class",
+			input: `<p class="Heading2">Two</p><p class="x titre-1">Un</p>` + bodyText,
+			want: []string{"h2 Two class", "h1 Un class"},
+		},
+		{
+			name: "class without a level is no heading",
+			input: `<p class="title">Not a heading</p><p class="subheading2">Nor this</p>` + bodyText,
+			want: nil,
+		},
+		{
+			name: "class on a long paragraph",
+			input: `<p class="Heading1">` + strings.Repeat("word ", 40) + `</p>`,
+			want: nil,
+		},
+		{
+			name: "font sizes",
+			input: `<p style="font-size: 20pt">Big</p><p><span style="font-size:16pt">Medium</span></p><p style="font-size: 12.5pt">Barely bigger</p>` + bodyText,
+			want: []string{"h1 Big fontsize", "h2 Medium fontsize"},
+		},
+		{
+			name: "bold paragraphs go below the other inferred headings",
+			input: `<p class="Heading1">Chapter</p><p><b>Section</b></p><p><b>bold</b> then normal</p>` + bodyText,
+			want: []string{"h1 Chapter class", "h2 Section bold"},
+		},
+		{
+			name: "only the outermost element is promoted",
+			input: `<p class="Heading1"><span class="Heading2"><strong>Title</strong></span></p>` + bodyText,
+			want: []string{"h1 Title class"},
+		},
+		{
+			name: "rules not enabled are not applied",
+			input: `<p class="Heading1">Chapter</p><p><b>Section</b></p>` + bodyText,
+			rules: []string{"bold"},
+			want: []string{"h1 Section bold"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testMeta()
+			if tt.rules != nil {
+				m.Config.HeadingInference = tt.rules
+			}
+			var got []string
+			for _, h := range inferHeadings(m, bodyOf(t, tt.input)) {
+				got = append(got, fmt.Sprint(h.Node.Data, " ", Text(h.Node), " ", h.Rule))
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreviewHeadings(t *testing.T) {
+	tests := []struct {
+		name, input	string
+		want		[]string // messages after the first
+	}{
+		{
+			name: "indentation follows the level",
+			input: `<h1>One</h1><h3>Three</h3>`,
+			want: []string{"h1 One markup", "    h3 Three markup"},
+		},
+		{
+			// the wiki extractor shifts the headings up, down to <h0>
+			name: "h0",
+			input: `<h0>Zero</h0><h1>One</h1>`,
+			want: []string{"h0 Zero markup", "h1 One markup"},
+		},
+		{
+			name: "inferred headings tell their rule",
+			input: `<p class="Heading2">Two</p>` + bodyText,
+			want: []string{"  h2 Two class"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			m := testMeta()
+			m.Log = zerolog.New(&buf)
+			content := bodyOf(t, tt.input)
+			previewHeadings(m, content, inferHeadings(m, content))
+			var got []string
+			dec := json.NewDecoder(&buf)
+			for {
+				var line struct{ Message, By string }
+				if err := dec.Decode(&line); err != nil {
+					break
+				}
+				if line.By != "" {
+					got = append(got, line.Message+" "+line.By)
+				}
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
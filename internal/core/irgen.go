@@ -3,6 +3,7 @@ package core
 import (
 	"fmt"
 	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 	"io"
 	"regexp"
 	"strings"
@@ -30,9 +31,6 @@ var (
 	ContentSelector = "body"
 	WantedTitleLen = 3
 	reCleanHTML = regexp.MustCompile(`^\s*(.*?)\s*$`)
-	Extractor ExtractorType
-	Article ArticleType
-	outFile, deckName string
 	IR3Fields = []string{"Title", "RealTitle", "Text", "Context"}
 )
 
@@ -52,22 +50,36 @@ type NoteType struct {
 	Txt		string
 	Context		string
 	Tags		[]string
+	// the section of the document the note is cut from
+	Section		*Section
+	// number of objects in Context
+	Objects		int
+	// references to figures and tables, see UsingRef
+	refs		*regexp.Regexp
+	// see CaptionInspector
+	captions	[]*regexp.Regexp
 	hasContent	bool
 }
 
 
 func Execute(ctx context.Context, m *meta.Meta) (success bool) {
+	_, success = NewJob(m).execute(ctx)
+	return
+}
+
+func (j *Job) execute(ctx context.Context) (notes int, success bool) {
+	m := j.m
 	m.LogConfig("config state at execution")
 	userGivenPath := m.Targ
-	Article.Name = strings.TrimSuffix(filepath.Base(userGivenPath), filepath.Ext(userGivenPath))
+	j.Article.Name = strings.TrimSuffix(filepath.Base(userGivenPath), filepath.Ext(userGivenPath))
 	m.Log.Debug().Msg("Execution started")
-	if m.Config.CollectionMedia == "" {
+	if m.Config.CollectionMedia == "" && !m.Config.DryRun {
 		m.Log.Error().Msg("Images can't be automatically imported because the path to collection.media has not been provided.")
 	}
 	if m.Config.DestDir == "" {
-		outFile = filepath.Join(filepath.Dir(userGivenPath), Article.Name + ".txt")
+		j.outFile = filepath.Join(filepath.Dir(userGivenPath), j.Article.Name + ".txt")
 	} else {
-		outFile = filepath.Join(m.Config.DestDir, Article.Name + ".txt")
+		j.outFile = filepath.Join(m.Config.DestDir, j.Article.Name + ".txt")
 	}
 	m.Log.Debug().
 		Bool("AbsPath?", filepath.IsAbs(userGivenPath)).
@@ -76,140 +88,316 @@ func Execute(ctx context.Context, m *meta.Meta) (success bool) {
 		Msg("init")	
 	var file []byte
 	var err error
+	var src *SourceType
 	if filepath.IsAbs(userGivenPath) {
 		if !canStat(userGivenPath) {
 			m.Log.Error().Msg("No input file specified or default file location unaccessible: " + userGivenPath)
 			return
 		}
-		Extractor = local
-		file, err = os.ReadFile(userGivenPath)
-		if err != nil {
+		j.Extractor = local
+		j.deckName = j.Article.Name
+		if reader, ok := readerFor(userGivenPath); ok {
+			s, err := reader.Read(m, userGivenPath)
+			if err != nil {
+				m.Log.Error().Err(err).Str("reader", reader.Name).Msg("couldn't read the input file")
+				return
+			}
+			src = &s
+			j.Extractor = src.extractor(reader.Name)
+		} else if file, err = os.ReadFile(userGivenPath); err != nil {
 			m.Log.Error().Err(err).Msg("can stat but not read specified input file, check permissions")
 			return
 		}
+	} else if extractor, article, ok := matchExtractor(userGivenPath); ok {
+		j.Extractor, j.Article = extractor, article
+		// deckName needed because we don't want the article named to be preceeded by "Wikipedia -" in Anki 
+		j.deckName = fmt.Sprint(j.Extractor.Name, " - ", j.Article.Name)
+		j.outFile = filepath.Join(m.Config.DestDir, j.deckName + ".txt")
 	} else {
-		for _, extractor := range extractors {
-			if extractor.Validator.MatchString(userGivenPath) {
-				Extractor = extractor
-				if extractor.Validator.NumSubexp() > 0 {
-					Article.Lang = extractor.Validator.FindStringSubmatch(userGivenPath)[1]
-				}
-				if extractor.Validator.NumSubexp() > 1 {
-					Article.Name, _ = url.QueryUnescape(extractor.Validator.FindStringSubmatch(userGivenPath)[2])
-					Article.Name = strings.ReplaceAll(Article.Name, "_", " ")
-				}
-				// deckName needed because we don't want the article named to be preceeded by "Wikipedia -" in Anki 
-				deckName = fmt.Sprint(Extractor.Name, " - ",Article.Name)
-				outFile = filepath.Join(m.Config.DestDir, deckName + ".txt")
-				break
-			}
+		// web pages unknown to the extractors are read like the pages of a book,
+		// using the content selector of the config
+		if src, err = webPage(m, userGivenPath, safeFilename(j.Article.Name)); err != nil {
+			m.Log.Error().Err(err).Msg("couldn't read the web page")
+			return
 		}
+		j.Extractor = src.extractor("web")
+		j.deckName = j.Article.Name
+	}
+	if m.Config.Deck != "" {
+		j.deckName = m.Config.Deck
 	}
 	m.Log.Debug().
-		Str("source", Extractor.Name).
-		Str("lang", Article.Lang).
-		Str("deckName",deckName).
-		Str("outFile",outFile).
+		Str("source", j.Extractor.Name).
+		Str("lang", j.Article.Lang).
+		Str("deckName", j.deckName).
+		Str("outFile", j.outFile).
 		Msg("")
-	launch := time.Now()
-	if Extractor.Name != "local" {
-		resp, err := http.Get(userGivenPath)
-		if resp.StatusCode != http.StatusOK {
-			m.Log.Error().Str("Received response status", resp.Status).Msg("HTTP")
-		} else {
-			m.Log.Info().Str("Received response status", resp.Status).Msg("HTTP")
+	var contentType string
+	if src != nil {
+		// readers only ever give away UTF-8
+		if m.Config.ChapterDecks {
+			return src.executeByChapter(ctx, j)
 		}
-		if err != nil {
+		return j.process(ctx, src.Join())
+	} else if !filepath.IsAbs(userGivenPath) {
+		if file, contentType, err = fetch(m, userGivenPath); err != nil {
 			m.Log.Error().Err(err).Msg("couldn't access URL")
 			return
 		}
-		file, err = io.ReadAll(resp.Body)
-		if err != nil {
-			m.Log.Error().Err(err).Msg("reading retrieved data failed")
-			return
+	}
+	file, enc, err := toUTF8(file, contentType, m.Config.Charset)
+	if err != nil {
+		m.Log.Error().Err(err).Msg("couldn't convert the document to UTF-8")
+		return
+	}
+	m.Log.Debug().Str("charset", enc).Msg("document decoded")
+	return j.process(ctx, file)
+}
+
+
+// webPage fetches the page at URL and extracts its content as the only chapter of a source
+func webPage(m *meta.Meta, URL, prefix string) (*SourceType, error) {
+	file, contentType, err := fetch(m, URL)
+	if err != nil {
+		return nil, err
+	}
+	if file, _, err = toUTF8(file, contentType, m.Config.Charset); err != nil {
+		return nil, err
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(file))
+	if err != nil {
+		return nil, err
+	}
+	src := SourceType{
+		Media: make(map[string][]byte),
+		Downloads: make(map[string]string),
+	}
+	c, _, err := src.chapterFromPage(m, bookChapter{Targ: URL}, doc, prefix)
+	if err != nil {
+		return nil, err
+	}
+	src.Chapters = []ChapterType{c}
+	return &src, nil
+}
+
+func matchExtractor(URL string) (x ExtractorType, article ArticleType, ok bool) {
+	for _, extractor := range extractors {
+		if !extractor.Validator.MatchString(URL) {
+			continue
 		}
+		sub := extractor.Validator.FindStringSubmatch(URL)
+		if extractor.Validator.NumSubexp() > 0 {
+			article.Lang = sub[1]
+		}
+		if extractor.Validator.NumSubexp() > 1 {
+			article.Name, _ = url.QueryUnescape(sub[2])
+			article.Name = strings.ReplaceAll(article.Name, "_", " ")
+		}
+		return extractor, article, true
+	}
+	return
+}
+
+func fetch(m *meta.Meta, URL string) (file []byte, contentType string, err error) {
+	resp, err := http.Get(URL)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		m.Log.Error().Str("Received response status", resp.Status).Str("url", URL).Msg("HTTP")
+	} else {
+		m.Log.Info().Str("Received response status", resp.Status).Str("url", URL).Msg("HTTP")
+	}
+	if file, err = io.ReadAll(resp.Body); err != nil {
+		return nil, "", fmt.Errorf("reading retrieved data failed: %w", err)
 	}
+	return file, resp.Header.Get("Content-Type"), nil
+}
+
+
+// process turns an UTF-8 HTML document into notes and imports them
+func (j *Job) process(ctx context.Context, file []byte) (notes int, success bool) {
+	m := j.m
+	launch := time.Now()
 	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(file))
 	if err != nil {
 		m.Log.Error().Err(err).Msg("couldn't prepare the document for parsing")
 		return
 	}
-	Extractor.Clean(doc, Article.Lang)
-	n := doc.Find(Extractor.ContentSelector)
+	j.Extractor.Clean(doc, j.Article.Lang)
+	n := doc.Find(j.Extractor.ContentSelector)
+	inferred := inferHeadings(m, n)
+	if m.Config.PreviewHeadings {
+		previewHeadings(m, n, inferred)
+		return 0, true
+	}
 	// drag the headings up until they are direct children of the content-containing tag
-	// this make things safe to monkey-patch with Cut()
-	processHeadings(n)
-	Extractor.TakeImgAlong(ctx, m, n)
-	// this returns InnerHTML
-	content, err := n.Html()
+	// so that the sections can be told apart by Split()
+	processHeadings(n, j.Extractor.ContentSelector)
+	j.Extractor.TakeImgAlong(ctx, m, n, j.Article.Name)
+	if n.Length() == 0 {
+		m.Log.Error().Str("selector", j.Extractor.ContentSelector).Msg("couldn't find the content in the document")
+		return
+	}
+	doc = Split(n.Nodes[0])
+	normalizeHeadings(doc)
+	cutAtMarkers(m, doc)
+	splitOversized(m, doc)
+	j.MergedRegister = mergeTiny(m, doc)
+	j.LocRegister, j.Tree = Preprocess(m, doc)
+	if m.Config.PinPreview {
+		if err := j.choosePins(stdin, os.Stdout); err != nil {
+			m.Log.Error().Err(err).Msg("couldn't pin the images")
+			return
+		}
+		return 0, true
+	}
+	filter, err := newSectionFilter(m, j.Extractor.Name)
 	if err != nil {
-		m.Log.Error().Err(err).Msg("couldn't access HTML content of file")
+		m.Log.Error().Err(err).Msg("couldn't compile the patterns of the sections to include or exclude")
 		return
 	}
-	content = "<cutpattern>" + Cut(content) + "</cutpattern>"
-	doc, err = goquery.NewDocumentFromReader(strings.NewReader(content))
+	refs := referencePattern(m)
+	pins, err := pinnedIn(m, j.Tree)
 	if err != nil {
-		m.Log.Error().Err(err).Msg("couldn't prepare the document for 2nd parsing")
+		m.Log.Error().Err(err).Msg("couldn't find the pinned images")
+		return
+	}
+	captions, err := compileCaptionPatterns(m)
+	if err != nil {
+		m.Log.Error().Err(err).Msg("couldn't compile the caption patterns")
+		return
+	}
+	capillaries, err := compileCapillaries(m)
+	if err != nil {
+		m.Log.Error().Err(err).Msg("couldn't compile the functions")
 		return
 	}
-	Preprocess(m, doc)
 	var Notes []NoteType
 	doc.Find("cutpattern").Each(func(i int, s *goquery.Selection) {
 		node := s.Nodes[0]
 		if Text(node) == "" {
 			return
 		}
-		loc, ok := LocRegister[node]
+		loc, ok := j.LocRegister[node]
 		if !ok {
 			m.Log.Error().
 				Str("sample", common.StringCapLen(InnerHTML(node), 200)).
 				Msg("loc not found for node " + node.Data)
 		}
-		TitleStack := loc.Stack()
-		if len(TitleStack) > 1 && Extractor.MustSkip(TitleStack) {
+		section := j.Tree.Of(node)
+		TitleStack := section.Stack()
+		if len(TitleStack) > 1 && j.Extractor.MustSkip(TitleStack) {
+			return
+		}
+		if !filter.keep(section) {
+			m.Log.Trace().Strs("path", section.Path()).Msg("section filtered out")
+			return
+		}
+		// the lead section, before the first heading
+		isOverview := section.Heading == nil
+		if isOverview && m.Config.SkipOverview {
 			return
 		}
 		Note := NoteType {
 			QNode: s,
-			ID: fmt.Sprintf("%s_%s %s", Article.Name, loc.miniStr(), fmtTl(TitleStack, -1)),
-			Title: fmtTl(TitleStack, m.Config.MaxTitles),
+			ID: fmt.Sprintf("%s_%s %s", j.Article.Name, loc.miniStr(), j.fmtTl(TitleStack, -1)),
+			Title: j.fmtTl(TitleStack, m.Config.MaxTitles),
 			Txt: InnerHTML(s.Nodes[0]),
+			Tags: m.Config.Tags,
+			Section: section,
+			refs: refs,
+			captions: captions,
+		}
+		if merged, ok := j.MergedRegister[node]; ok {
+			Note.ID, Note.Title = spanTitles(Note.ID, Note.Title, merged)
+		}
+		if isOverview {
+			// its ID is that of any other note, for those imported before it was an overview to match
+			Note.Txt, Note.Context, Note.Objects = Note.LeadCxt()
+		} else {
+			// done first for MkCxt not to add these objects twice
+			Note.Txt, Note.Context, Note.Objects = Note.MvAddendumToCxt(m.Config.MoveToContext)
 		}
-		Note.Context = Note.MkCxt(m, loc, TitleStack)
+		// pinned objects come before those of the capillaries, which skip them
+		src, objects := Note.PinnedCxt(pins)
+		Note.Context += src
+		Note.Objects += objects
+		src, objects = Note.MkCxt(capillaries, TitleStack)
+		Note.Context += src
+		Note.Objects += objects
 		// keep this after MkCxt to be able to ez check for duplicate img
 		Note.Txt = gohtml.Format(Note.Txt)
 		Notes = append(Notes, Note)
 	})
+	if m.Config.Outline {
+		j.printOutline(os.Stdout, Notes)
+		return len(Notes), true
+	}
 	if ok := common.QueryAnkiConnectMediaDir(m); ok {
-		m.Log.Info().Msg("Importing to Anki over AnkiConnect...")
 		if err := common.VerifyNoteTypeFields(m, "IR3", IR3Fields); err != nil {
 			m.Log.Error().
 				Err(err).
 				Msg("fields for Notetype IR3 reported by AnkiConnect aren't the ones that irgen requires")
 			return
 		}
-
-		common.CreateDeck(m, deckName)
-		for _, Note := range Notes {
-			fields := map[string]string{
-				IR3Fields[0]:	Note.ID,
-				IR3Fields[1]:	Note.Title,
-				IR3Fields[2]:	Note.Txt,
-				IR3Fields[3]:	Note.Context,
-			}
-			if err := common.AddNote(m, deckName, "IR3", fields, Note.Tags); err != nil {
-				m.Log.Error().
-					Str("title", Note.Title).
-					Msg("couldn't create following note")
-			} else {
+		plan, err := j.planImport(Notes)
+		if err != nil {
+			m.Log.Error().Err(err).Str("deck", j.deckName).Msg("couldn't look up the notes already in the deck")
+			return
+		}
+		if m.Config.DryRun {
+			j.reportPlan(Notes, plan)
+			return len(Notes), true
+		}
+		m.Log.Info().Msg("Importing to Anki over AnkiConnect...")
+		common.CreateDeck(m, j.deckName)
+		for i, Note := range Notes {
+			switch plan[i].Action {
+			case actionSkip:
 				m.Log.Trace().
 					Str("title", Note.Title).
-					Msg("created note")
+					Msg("note already in the deck")
+			case actionUpdate:
+				fields := map[string]string{
+					IR3Fields[1]:	Note.Title,
+					IR3Fields[3]:	Note.Context,
+				}
+				if err := common.UpdateNoteFields(m, plan[i].NoteID, fields); err != nil {
+					m.Log.Error().
+						Str("title", Note.Title).
+						Msg("couldn't update following note")
+				} else {
+					m.Log.Trace().
+						Str("title", Note.Title).
+						Msg("updated note")
+				}
+			default:
+				fields := map[string]string{
+					IR3Fields[0]:	Note.ID,
+					IR3Fields[1]:	Note.Title,
+					IR3Fields[2]:	Note.Txt,
+					IR3Fields[3]:	Note.Context,
+				}
+				if err := common.AddNote(m, j.deckName, "IR3", fields, Note.Tags); err != nil {
+					m.Log.Error().
+						Str("title", Note.Title).
+						Msg("couldn't create following note")
+				} else {
+					m.Log.Trace().
+						Str("title", Note.Title).
+						Msg("created note")
+				}
 			}
 		}
+	} else if m.Config.DryRun {
+		m.Log.Warn().Str("outFile", j.outFile).Msg("AnkiConnect unavailable, the notes would be written to a TSV (CSV) file")
+		j.reportPlan(Notes, make([]plannedNote, len(Notes)))
+		return len(Notes), true
 	} else {
 		m.Log.Warn().Msg("AnkiConnect unavailable, writing notes to TSV (CSV) file to import them manually")
-		csvout, err := os.OpenFile(outFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+		csvout, err := os.OpenFile(j.outFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
 		if err != nil {
 			m.Log.Error().Err(err).Msg("couldn't access output CSV file for writing")
 			return
@@ -225,8 +413,7 @@ func Execute(ctx context.Context, m *meta.Meta) (success bool) {
 	m.Log.Info().Int("total notes", len(Notes)).Msg("")
 	elapsed := time.Since(launch)
 	m.Log.Info().Msgf("Done in %s", elapsed)
-	success = true
-	return
+	return len(Notes), true
 }
 
 
@@ -240,7 +427,7 @@ func (Rating RatingType) Swap(i, j int) {
 	Rating.IntSlice.Swap(i, j)
 }
 
-func fmtTl(TitleStack []*html.Node, max int) (s string) {
+func (j *Job) fmtTl(TitleStack []*html.Node, max int) (s string) {
 	added := 0
 	for _, n := range(TitleStack[1:]) {
 		if max < 0 { // for ID (Title in Anki)
@@ -252,7 +439,7 @@ func fmtTl(TitleStack []*html.Node, max int) (s string) {
 	}
 	s = strings.TrimPrefix(s, ": ")
 	if s == "" {
-		s = "<span class=heading>"+Article.Name+"</span>"
+		s = "<span class=heading>"+j.Article.Name+"</span>"
 	}
 	return
 }
@@ -280,18 +467,18 @@ func RenderNode(n *html.Node) string {
 }
 
 
-func processHeadings(contentNode *goquery.Selection) {
+func processHeadings(contentNode *goquery.Selection, contentSelector string) {
 	// Keep processing until no more changes are needed
 	changed := true
 	for changed {
 		changed = false
 		
-		headings := contentNode.Find("h1,h2,h3,h4,h5,h6")
+		headings := findHeadings(contentNode)
 		
 		headings.Each(func(i int, heading *goquery.Selection) {
 			parent := heading.Parent()
 			// Only process if parent is not the content selector
-			if !parent.Is(Extractor.ContentSelector) {
+			if !parent.Is(contentSelector) {
 				headingHtml, err := heading.Html()
 				if err == nil {
 					// Insert heading before its parent
@@ -304,25 +491,56 @@ func processHeadings(contentNode *goquery.Selection) {
 	}
 }
 
-var reHeading = regexp.MustCompile("(?si)<h[0-9]+[^<]*?>(.*?)</h[0-9]+>")
-func Cut(str string) string {
-	var done []string
-	for _, h := range reHeading.FindAllStringSubmatch(str, -1) {
-		outer := h[0]
-		inner := h[1]
-		// ensure only actual html pairs of tags get found
-		if reHeading.MatchString(inner) {
-			continue
+// Split moves the children of the content node into a new document whose body
+// alternates between the headings and <cutpattern> nodes holding what lies
+// between them. A cutpattern always follows a heading, even an empty one.
+func Split(content *html.Node) *goquery.Document {
+	root := newElement("html")
+	root.AppendChild(newElement("head"))
+	body := newElement("body")
+	root.AppendChild(body)
+	cut := newElement("cutpattern")
+	body.AppendChild(cut)
+	for c := content.FirstChild; c != nil; {
+		next := c.NextSibling
+		content.RemoveChild(c)
+		if isHeading(c) {
+			body.AppendChild(c)
+			cut = newElement("cutpattern")
+			body.AppendChild(cut)
+		} else {
+			cut.AppendChild(c)
 		}
-		// string of individual heading may occasionally be the same
-		// throughout the document, make sure to not ReplaceAll multiple time
-		if contains(done, outer) {
-			continue
+		c = next
+	}
+	doc := &html.Node{Type: html.DocumentNode}
+	doc.AppendChild(root)
+	return goquery.NewDocumentFromNode(doc)
+}
+
+func newElement(tag string) *html.Node {
+	return &html.Node{Type: html.ElementNode, Data: tag, DataAtom: atom.Lookup([]byte(tag))}
+}
+
+// Besides h1-h6, headings can be h7, h8... in documents whose structure is deeper, such
+// as legal texts. n.Data rather than n.DataAtom: the extractors renumber headings by
+// renaming them.
+func isHeading(n *html.Node) bool {
+	if n.Type != html.ElementNode || len(n.Data) < 2 || n.Data[0] != 'h' {
+		return false
+	}
+	for _, r := range n.Data[1:] {
+		if r < '0' || r > '9' {
+			return false
 		}
-		str = strings.ReplaceAll(str, outer, "</cutpattern>" + outer + "<cutpattern>")
-		done = append(done, outer)
 	}
-	return str
+	return true
+}
+
+func findHeadings(s *goquery.Selection) *goquery.Selection {
+	return s.Find("*").FilterFunction(func(i int, e *goquery.Selection) bool {
+		return isHeading(e.Nodes[0])
+	})
 }
 
 func contains[T comparable](arr []T, i T) bool {
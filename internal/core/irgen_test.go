@@ -0,0 +1,68 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+const webPageHTML = `<html><head><title>Doc</title></head><body>
+<nav>menu</nav>
+<article>
+<h1>Doc</h1><p>lead</p>
+<h2>Part</h2><p>text</p><img src="img/a.png">
+</article>
+</body></html>`
+
+// pages whose URL matches no extractor are read with the content selector of the config
+func TestExecuteUnknownURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/doc.html":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(webPageHTML))
+		case "/img/a.png":
+			w.Write([]byte("png"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	tests := []struct {
+		name, selector	string
+		wantSuccess	bool
+	}{
+		{name: "whole body", wantSuccess: true},
+		{name: "content selector", selector: "article", wantSuccess: true},
+		{name: "content selector matching nothing", selector: "main"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testMeta()
+			m.Targ = srv.URL + "/doc.html"
+			// the outline is printed instead of querying AnkiConnect
+			m.Config.Outline = true
+			m.Config.CollectionMedia = t.TempDir()
+			m.Config.ContentSelector = tt.selector
+			j := NewJob(m)
+			notes, success := j.execute(context.Background())
+			if success != tt.wantSuccess {
+				t.Fatalf("got success %v, want %v", success, tt.wantSuccess)
+			}
+			if !tt.wantSuccess {
+				return
+			}
+			if j.Extractor.Name != "web" {
+				t.Errorf("got extractor %q, want web", j.Extractor.Name)
+			}
+			if notes == 0 {
+				t.Error("no notes made")
+			}
+			if !canStat(filepath.Join(m.Config.CollectionMedia, "doc_0_a.png")) {
+				t.Error("img of the page not downloaded to collection.media")
+			}
+		})
+	}
+}
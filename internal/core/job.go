@@ -0,0 +1,36 @@
+package core
+
+import (
+	"golang.org/x/net/html"
+
+	"github.com/tassa-yoniso-manasi-karoto/irgen/internal/meta"
+)
+
+// Job holds the state of the processing of one document, from its fetching to the
+// import of its notes. Jobs share nothing but the images already looked up, so
+// several of them can run at the same time, e.g. in the GUI or when irgen is
+// embedded in another program.
+type Job struct {
+	// a copy of the meta the job was made from, sharing nothing with it but its Koanf,
+	// for the job to alter its config as it pleases
+	m		*meta.Meta
+	Extractor	ExtractorType
+	Article		ArticleType
+	outFile, deckName	string
+	// CUTPATTERN-NODE TO LOCATION
+	LocRegister	map[*html.Node]Location
+	// the sections of the document, see Preprocess
+	Tree		*SectionTree
+	// cutpatterns into which tiny sections were merged → titles of these sections
+	MergedRegister	map[*html.Node][]string
+}
+
+func NewJob(m *meta.Meta) *Job {
+	jm := *m
+	jm.Config = m.Config.Clone()
+	return &Job{
+		m: &jm,
+		LocRegister: make(map[*html.Node]Location),
+		MergedRegister: make(map[*html.Node][]string),
+	}
+}
@@ -0,0 +1,30 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/tassa-yoniso-manasi-karoto/irgen/internal/meta"
+)
+
+func TestNewJobSharesNoConfig(t *testing.T) {
+	m := testMeta()
+	m.Config.Tags = []string{"bones"}
+	m.Config.Pins = []meta.Pin{{File: "a.png"}}
+	m.Config.Filters = map[string]meta.SectionFilter{"Wikipedia": {Include: []string{"**"}}}
+	m.Config.Functions[0].Collect = []string{"img"}
+	j1, j2 := NewJob(m), NewJob(m)
+	// e.g. what executeInput and the pin picker do
+	j1.m.Config.Tags[0] = "forearm"
+	j1.m.Config.Pins[0].File = "b.png"
+	j1.m.Config.Filters["Wikipedia"].Include[0] = "Anatomy/**"
+	j1.m.Config.Filters["local"] = meta.SectionFilter{}
+	j1.m.Config.Functions[0].Scope = 5
+	j1.m.Config.Functions[0].Collect[0] = "table"
+	for name, other := range map[string]*meta.Meta{"meta": m, "other job": j2.m} {
+		got := fmt.Sprint(other.Config.Tags, other.Config.Pins, other.Config.Filters, other.Config.Functions[0].Scope, other.Config.Functions[0].Collect)
+		if want := "[bones] [{  a.png}] map[Wikipedia:{[**] []}] 1 [img]"; got != want {
+			t.Errorf("%s: got %s, want %s", name, got, want)
+		}
+	}
+}
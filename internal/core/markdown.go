@@ -0,0 +1,245 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	gmhtml "github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+
+	"github.com/tassa-yoniso-manasi-karoto/irgen/internal/meta"
+)
+
+var markdown = ReaderType{
+	Name: "Markdown",
+	Exts: markdownExts,
+	Folders: true,
+	Read: readMarkdown,
+}
+
+var (
+	markdownExts = []string{".md", ".markdown"}
+	reFrontMatter = regexp.MustCompile(`(?s)^---\r?\n.*?\r?\n---\r?\n`)
+	// Obsidian flavored embeds and links: ![[pic.png|300]], [[Note#Part|alias]]
+	reWikiEmbed = regexp.MustCompile(`!\[\[([^\]|#]+)(?:[^\]]*)\]\]`)
+	reWikiLink = regexp.MustCompile(`\[\[([^\]|]+)(?:\|([^\]]+))?\]\]`)
+
+	md = goldmark.New(
+		goldmark.WithExtensions(extension.GFM, mathExtension{}),
+		goldmark.WithRendererOptions(gmhtml.WithUnsafe()),
+	)
+)
+
+// readMarkdown reads a Markdown file or, if p is a directory, every Markdown
+// file beneath it in lexical order, as many chapters.
+func readMarkdown(m *meta.Meta, p string) (src SourceType, err error) {
+	root := p
+	files := []string{p}
+	if info, err := os.Stat(p); err == nil && info.IsDir() {
+		files = nil
+		filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() && contains(markdownExts, strings.ToLower(filepath.Ext(path))) {
+				files = append(files, path)
+			}
+			return nil
+		})
+		slices.Sort(files)
+	} else {
+		root = filepath.Dir(p)
+	}
+	prefix := strings.TrimSuffix(filepath.Base(p), filepath.Ext(p))
+	src.Media = make(map[string][]byte)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			m.Log.Error().Err(err).Str("file", file).Msg("couldn't read Markdown file")
+			continue
+		}
+		data = reFrontMatter.ReplaceAll(data, nil)
+		data = reWikiEmbed.ReplaceAll(data, []byte("![]($1)"))
+		data = reWikiLink.ReplaceAllFunc(data, func(b []byte) []byte {
+			sub := reWikiLink.FindSubmatch(b)
+			if len(sub[2]) != 0 {
+				return sub[2]
+			}
+			return sub[1]
+		})
+		var buf bytes.Buffer
+		if err := md.Convert(data, &buf); err != nil {
+			m.Log.Error().Err(err).Str("file", file).Msg("couldn't convert Markdown to HTML")
+			continue
+		}
+		doc, err := goquery.NewDocumentFromReader(&buf)
+		if err != nil {
+			continue
+		}
+		doc.Find("img").Each(func(i int, s *goquery.Selection) {
+			ref, _ := s.Attr("src")
+			if ref == "" || strings.Contains(ref, "://") || strings.HasPrefix(ref, "data:") {
+				return
+			}
+			imgPath := findMarkdownMedia(root, filepath.Dir(file), ref)
+			if imgPath == "" {
+				m.Log.Warn().Str("src", ref).Str("file", file).Msg("img referenced by Markdown file not found")
+				return
+			}
+			img, err := os.ReadFile(imgPath)
+			if err != nil {
+				m.Log.Warn().Err(err).Str("path", imgPath).Msg("can't read img to copy")
+				return
+			}
+			rel, _ := filepath.Rel(root, imgPath)
+			filename := mediaFilename(prefix, filepath.ToSlash(rel))
+			src.Media[filename] = img
+			s.SetAttr("src", filename)
+		})
+		chapter := ChapterType{Title: strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))}
+		h, err := doc.Html()
+		if err != nil {
+			continue
+		}
+		chapter.HTML = []byte(h)
+		src.Chapters = append(src.Chapters, chapter)
+	}
+	if len(src.Chapters) == 0 {
+		return src, fmt.Errorf("no readable Markdown file found")
+	}
+	return
+}
+
+// relative paths are resolved from the directory of the file, but embeds
+// of Obsidian only give a filename that can be anywhere in the vault
+func findMarkdownMedia(root, dir, ref string) (found string) {
+	if unescaped, err := url.PathUnescape(ref); err == nil {
+		ref = unescaped
+	}
+	for _, candidate := range []string{filepath.Join(dir, ref), filepath.Join(root, ref)} {
+		if canStat(candidate) {
+			return candidate
+		}
+	}
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && d.Name() == filepath.Base(ref) {
+			found = path
+			return fs.SkipAll
+		}
+		return nil
+	})
+	return
+}
+
+
+// LaTeX math: $...$ and $$...$$ are given to Anki in its own MathJax
+// delimiters, \(...\) and \[...\], with their content left untouched.
+type mathExtension struct{}
+
+type mathNode struct {
+	ast.BaseInline
+	Display	bool
+	Content	[]byte
+}
+
+var kindMath = ast.NewNodeKind("Math")
+
+func (n *mathNode) Kind() ast.NodeKind {
+	return kindMath
+}
+
+func (n *mathNode) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Content": string(n.Content)}, nil)
+}
+
+func (mathExtension) Extend(gm goldmark.Markdown) {
+	gm.Parser().AddOptions(parser.WithInlineParsers(util.Prioritized(mathParser{}, 150)))
+	gm.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(mathRenderer{}, 150)))
+}
+
+type mathParser struct{}
+
+func (mathParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+func (mathParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+	delim := []byte("$")
+	if bytes.HasPrefix(line, []byte("$$")) {
+		delim = []byte("$$")
+	}
+	display := len(delim) == 2
+	// like pandoc, no space may follow the opening $ of inline math, which
+	// spares prices like "$5 and $6"
+	if !display && (len(line) < 2 || line[1] == ' ' || line[1] == '\t') {
+		return nil
+	}
+	line = line[len(delim):]
+	end := bytes.Index(line, delim)
+	if !display {
+		for end > 0 && (line[end-1] == ' ' || (end+1 < len(line) && line[end+1] >= '0' && line[end+1] <= '9')) {
+			next := bytes.Index(line[end+1:], delim)
+			if next < 0 {
+				end = -1
+				break
+			}
+			end += next + 1
+		}
+	}
+	if end > 0 {
+		block.Advance(len(delim) + end + len(delim))
+		return &mathNode{Display: display, Content: slices.Clone(line[:end])}
+	}
+	if !display {
+		return nil
+	}
+	// display math may span several lines of the paragraph
+	pos, seg := block.Position()
+	content := slices.Clone(line)
+	block.AdvanceLine()
+	for {
+		l, _ := block.PeekLine()
+		if l == nil {
+			block.SetPosition(pos, seg)
+			return nil
+		}
+		if end := bytes.Index(l, delim); end >= 0 {
+			content = append(content, l[:end]...)
+			block.Advance(end + len(delim))
+			return &mathNode{Display: true, Content: bytes.TrimSpace(content)}
+		}
+		content = append(content, l...)
+		block.AdvanceLine()
+	}
+}
+
+type mathRenderer struct{}
+
+func (mathRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindMath, func(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		n := node.(*mathNode)
+		open, close := `\(`, `\)`
+		if n.Display {
+			open, close = `\[`, `\]`
+		}
+		w.WriteString(open)
+		w.Write(util.EscapeHTML(n.Content))
+		w.WriteString(close)
+		return ast.WalkSkipChildren, nil
+	})
+}
@@ -0,0 +1,108 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadMarkdown(t *testing.T) {
+	tests := []struct {
+		name, input	string
+		want		[]string
+		notWant		[]string
+	}{
+		{
+			name: "front matter is dropped",
+			input: "---\ntitle: x\n---\n# Title\n\ntext",
+			want: []string{"<h1>Title</h1>", "<p>text</p>"},
+			notWant: []string{"title: x"},
+		},
+		{
+			name: "wiki links keep their alias or target",
+			input: "see [[Other note|the other]] and [[Third]]",
+			want: []string{"see the other and Third"},
+		},
+		{
+			name: "inline and display math",
+			input: "let $x^2$ be\n\n$$\na < b\n$$",
+			want: []string{`\(x^2\)`, `\[a &lt; b\]`},
+		},
+		{
+			name: "prices are not math",
+			input: "costs $5 and $6",
+			want: []string{"costs $5 and $6"},
+		},
+		{
+			name: "GFM tables",
+			input: "| a | b |\n|---|---|\n| 1 | 2 |",
+			want: []string{"<table>", "<td>1</td>"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), "note.md")
+			if err := os.WriteFile(p, []byte(tt.input), 0644); err != nil {
+				t.Fatal(err)
+			}
+			src, err := readMarkdown(testMeta(), p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(src.Chapters) != 1 || src.Chapters[0].Title != "note" {
+				t.Fatalf("got chapters %+v, want one named note", src.Chapters)
+			}
+			got := string(src.Chapters[0].HTML)
+			for _, s := range tt.want {
+				if !strings.Contains(got, s) {
+					t.Errorf("%q not found in\n%s", s, got)
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(got, s) {
+					t.Errorf("%q found in\n%s", s, got)
+				}
+			}
+		})
+	}
+}
+
+func TestReadMarkdownVault(t *testing.T) {
+	vault := filepath.Join(t.TempDir(), "vault")
+	files := map[string]string{
+		"b.md":			"# B\n\n![[pic.png|300]]",
+		"a/one.md":		"# One\n\n![](../attachments/pic.png)",
+		"attachments/pic.png":	"png",
+		"notes.txt":		"ignored",
+	}
+	for name, content := range files {
+		p := filepath.Join(vault, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src, err := readMarkdown(testMeta(), vault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for _, c := range src.Chapters {
+		titles = append(titles, c.Title)
+	}
+	if strings.Join(titles, ",") != "one,b" {
+		t.Fatalf("got chapters %v, want [one b]", titles)
+	}
+	const filename = "vault_attachments_pic.png"
+	if _, ok := src.Media[filename]; !ok || len(src.Media) != 1 {
+		t.Errorf("got media %v, want only %s", src.Media, filename)
+	}
+	for _, c := range src.Chapters {
+		if !strings.Contains(string(c.HTML), `src="`+filename+`"`) {
+			t.Errorf("img of %s not rewritten:\n%s", c.Title, c.HTML)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"github.com/tassa-yoniso-manasi-karoto/irgen/internal/meta"
+)
+
+// MHTML is what browsers produce with "save as single file": a MIME multipart/related
+// message whose root part is the HTML of the page and the other parts its resources,
+// identified by their Content-Location (or Content-ID).
+var mhtml = ReaderType{
+	Name: "MHTML",
+	Exts: []string{".mhtml", ".mht"},
+	Read: readMHTML,
+}
+
+type mimePart struct {
+	ContentType, Location, ID string
+	Data []byte
+}
+
+func readMHTML(m *meta.Meta, p string) (src SourceType, err error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	tp := textproto.NewReader(bufio.NewReader(f))
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return src, fmt.Errorf("couldn't read MIME header of the archive: %w", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return src, fmt.Errorf("not a MIME multipart archive (Content-Type %q)", header.Get("Content-Type"))
+	}
+	var parts []mimePart
+	mr := multipart.NewReader(tp.R, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return src, fmt.Errorf("malformed MIME part: %w", err)
+		}
+		// quoted-printable is decoded by the multipart reader, base64 isn't
+		var r io.Reader = part
+		if strings.EqualFold(part.Header.Get("Content-Transfer-Encoding"), "base64") {
+			r = base64.NewDecoder(base64.StdEncoding, part)
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			m.Log.Warn().Err(err).Str("location", part.Header.Get("Content-Location")).Msg("couldn't decode MIME part")
+			continue
+		}
+		parts = append(parts, mimePart{
+			ContentType: part.Header.Get("Content-Type"),
+			Location: part.Header.Get("Content-Location"),
+			ID: strings.Trim(part.Header.Get("Content-ID"), "<>"),
+			Data: data,
+		})
+	}
+	root := -1
+	for i, part := range parts {
+		if start := strings.Trim(params["start"], "<>"); start != "" && part.ID == start {
+			root = i
+			break
+		}
+		if mt, _, _ := mime.ParseMediaType(part.ContentType); root == -1 && mt == "text/html" {
+			root = i
+		}
+	}
+	if root == -1 {
+		return src, fmt.Errorf("no HTML part found in the archive")
+	}
+	file, enc, err := toUTF8(parts[root].Data, parts[root].ContentType, m.Config.Charset)
+	if err != nil {
+		return
+	}
+	m.Log.Debug().
+		Int("parts", len(parts)).
+		Str("root", parts[root].Location).
+		Str("charset", enc).
+		Msg("MHTML archive read")
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(file))
+	if err != nil {
+		return
+	}
+	prefix := strings.TrimSuffix(filepath.Base(p), filepath.Ext(p))
+	base, _ := url.Parse(parts[root].Location)
+	src.Media = extractDataURIs(doc.Selection, prefix)
+	// by part, i.e. by Content-Location or Content-ID, for an image used twice to be written once
+	filenames := make(map[*mimePart]string)
+	doc.Find("img").Each(func(i int, s *goquery.Selection) {
+		ref, _ := s.Attr("src")
+		part := findMIMEPart(parts, base, ref)
+		if part == nil {
+			return
+		}
+		filename, ok := filenames[part]
+		if !ok {
+			filename = mediaFilename(prefix, resourceName(*part, len(src.Media)))
+			filenames[part] = filename
+			src.Media[filename] = part.Data
+		}
+		s.SetAttr("src", filename)
+		// the alternatives can't be resolved
+		s.RemoveAttr("srcset")
+	})
+	title := strings.TrimSpace(doc.Find("title").First().Text())
+	if title == "" {
+		title = header.Get("Subject")
+	}
+	h, err := doc.Html()
+	if err != nil {
+		return
+	}
+	src.Chapters = []ChapterType{{Title: title, HTML: []byte(h)}}
+	return
+}
+
+func findMIMEPart(parts []mimePart, base *url.URL, ref string) *mimePart {
+	if ref == "" {
+		return nil
+	}
+	if cid, found := strings.CutPrefix(ref, "cid:"); found {
+		for i := range parts {
+			if parts[i].ID == cid {
+				return &parts[i]
+			}
+		}
+		return nil
+	}
+	abs := ref
+	if base != nil {
+		if u, err := base.Parse(ref); err == nil {
+			abs = u.String()
+		}
+	}
+	for i := range parts {
+		if parts[i].Location == abs || parts[i].Location == ref {
+			return &parts[i]
+		}
+	}
+	return nil
+}
+
+// resourceName derives a filename from the location of the part, with an extension
+// deduced from its type if needed, made unique by n as basenames are often shared
+func resourceName(part mimePart, n int) string {
+	name := "resource"
+	if u, err := url.Parse(part.Location); err == nil && path.Base(u.Path) != "/" && path.Base(u.Path) != "." {
+		name = path.Base(u.Path)
+	}
+	if path.Ext(name) == "" {
+		name += extForType(part.ContentType)
+	}
+	return fmt.Sprint(n, "_", name)
+}
+
+func extForType(contentType string) string {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	// mime's tables put the least common extensions first for these
+	switch mt {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/svg+xml":
+		return ".svg"
+	}
+	if exts, _ := mime.ExtensionsByType(mt); len(exts) != 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
+
+// extractDataURIs replaces the images inlined as data: URIs, like those of the archives
+// made by SingleFile, by files that can be put in collection.media.
+func extractDataURIs(n *goquery.Selection, prefix string) map[string][]byte {
+	media := make(map[string][]byte)
+	n.Find("img[src^='data:']").Each(func(i int, s *goquery.Selection) {
+		ref, _ := s.Attr("src")
+		header, payload, found := strings.Cut(strings.TrimPrefix(ref, "data:"), ",")
+		if !found {
+			return
+		}
+		var data []byte
+		mt, isBase64 := strings.CutSuffix(header, ";base64")
+		if isBase64 {
+			var err error
+			if data, err = base64.StdEncoding.DecodeString(payload); err != nil {
+				return
+			}
+		} else {
+			unescaped, err := url.PathUnescape(payload)
+			if err != nil {
+				return
+			}
+			data = []byte(unescaped)
+		}
+		sum := sha1.Sum(data)
+		filename := safeFilename(fmt.Sprintf("%s_%x%s", prefix, sum[:8], extForType(mt)))
+		media[filename] = data
+		s.SetAttr("src", filename)
+		s.RemoveAttr("srcset")
+	})
+	return media
+}
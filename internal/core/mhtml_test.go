@@ -0,0 +1,137 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// mhtmlArchive assembles a multipart/related archive, parts being given with their headers
+func mhtmlArchive(parts ...string) string {
+	s := "From: <Saved by Blink>\r\nSubject: Saved page\r\nMIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/related; type=\"text/html\"; boundary=\"B\"\r\n\r\n"
+	for _, part := range parts {
+		s += "--B\r\n" + part + "\r\n"
+	}
+	return s + "--B--\r\n"
+}
+
+func TestReadMHTML(t *testing.T) {
+	tests := []struct {
+		name, archive	string
+		wantTitle	string
+		want		[]string
+		wantMedia	[]string
+		wantErr		bool
+	}{
+		{
+			name: "quoted-printable page with a base64 image",
+			archive: mhtmlArchive(
+				"Content-Type: text/html\r\nContent-Transfer-Encoding: quoted-printable\r\nContent-Location: https://example.org/doc/page.html\r\n\r\n"+
+					"<title>Page</title><p class=3D\"x\">caf=C3=A9</p><img src=3D\"img/a.png\" srcset=3D\"img/a2.png 2x\">",
+				"Content-Type: image/png\r\nContent-Transfer-Encoding: base64\r\nContent-Location: https://example.org/doc/img/a.png\r\n\r\ncG5n",
+			),
+			wantTitle: "Page",
+			want: []string{`<p class="x">café</p>`, `<img src="page_0_a.png"/>`},
+			wantMedia: []string{"page_0_a.png"},
+		},
+		{
+			name: "image referenced by Content-ID, extension from its type",
+			archive: mhtmlArchive(
+				"Content-Type: text/html\r\nContent-Location: https://example.org/\r\n\r\n<img src=\"cid:pic@x\">",
+				"Content-Type: image/jpeg\r\nContent-ID: <pic@x>\r\nContent-Location: https://example.org/\r\n\r\njpg",
+			),
+			wantTitle: "Saved page",
+			want: []string{`<img src="page_0_resource.jpg"/>`},
+			wantMedia: []string{"page_0_resource.jpg"},
+		},
+		{
+			name: "image referenced twice is written once",
+			archive: mhtmlArchive(
+				"Content-Type: text/html\r\nContent-Location: https://example.org/doc/page.html\r\n\r\n"+
+					"<img src=\"img/a.png\"><img src=\"b.png\"><img src=\"https://example.org/doc/img/a.png\">",
+				"Content-Type: image/png\r\nContent-Location: https://example.org/doc/img/a.png\r\n\r\npng",
+				"Content-Type: image/png\r\nContent-Location: https://example.org/doc/b.png\r\n\r\npng",
+			),
+			want: []string{`<img src="page_0_a.png"/><img src="page_1_b.png"/><img src="page_0_a.png"/>`},
+			wantMedia: []string{"page_0_a.png", "page_1_b.png"},
+		},
+		{
+			name: "data URI",
+			archive: mhtmlArchive(
+				"Content-Type: text/html\r\n\r\n<img src=\"data:image/png;base64,cG5n\">",
+			),
+			want: []string{`<img src="page_`},
+			wantMedia: []string{"page_"},
+		},
+		{
+			name: "charset of the root part",
+			archive: mhtmlArchive(
+				"Content-Type: text/html; charset=windows-1252\r\n\r\n<p>caf\xe9</p>",
+			),
+			want: []string{"<p>café</p>"},
+		},
+		{
+			name: "unresolved image is left alone",
+			archive: mhtmlArchive(
+				"Content-Type: text/html\r\n\r\n<img src=\"https://elsewhere.org/b.png\">",
+			),
+			want: []string{`<img src="https://elsewhere.org/b.png"/>`},
+		},
+		{
+			name: "no HTML part",
+			archive: mhtmlArchive(
+				"Content-Type: image/png\r\n\r\npng",
+			),
+			wantErr: true,
+		},
+		{
+			name: "not an archive",
+			archive: "<html><body>plain</body></html>",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), "page.mhtml")
+			if err := os.WriteFile(p, []byte(tt.archive), 0644); err != nil {
+				t.Fatal(err)
+			}
+			src, err := readMHTML(testMeta(), p)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(src.Chapters) != 1 {
+				t.Fatalf("got %d chapters, want 1", len(src.Chapters))
+			}
+			if tt.wantTitle != "" && src.Chapters[0].Title != tt.wantTitle {
+				t.Errorf("got title %q, want %q", src.Chapters[0].Title, tt.wantTitle)
+			}
+			got := string(src.Chapters[0].HTML)
+			for _, s := range tt.want {
+				if !strings.Contains(got, s) {
+					t.Errorf("%q not found in\n%s", s, got)
+				}
+			}
+			if len(src.Media) != len(tt.wantMedia) {
+				t.Fatalf("got media %v, want %v", src.Media, tt.wantMedia)
+			}
+			for _, prefix := range tt.wantMedia {
+				found := false
+				for filename := range src.Media {
+					found = found || strings.HasPrefix(filename, prefix)
+				}
+				if !found {
+					t.Errorf("no media named %s… in %v", prefix, src.Media)
+				}
+			}
+		})
+	}
+}
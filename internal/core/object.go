@@ -1,25 +1,32 @@
-
 package core
 
 import (
 	"fmt"
+	"regexp"
+	"strings"
 	"golang.org/x/net/html"
 	
 	"github.com/PuerkitoBio/goquery"
+
+	"github.com/tassa-yoniso-manasi-karoto/irgen/internal/meta"
 )
 
 /*
-this file was dedicated to try to guess find a user-predefined sequence that is part of the context of an image/table ie. captions or
-a title but yet not properly defined through HTML tags. 
-It needs instruction that are tailored made to how figures appear in the document and therefore was full of hardcoded stuff.
-Need rewriting from scratch
+this file is dedicated to find the sequence that is part of the context of an image/table ie. captions
+but yet not properly defined through HTML tags: in textbooks converted to HTML, the caption of a figure
+is often a mere paragraph next to it starting with "Figure 4:". Such a paragraph is a caption if it
+starts with a reference (see UsingRef) or matches one of the CaptionPatterns of the config.
 */
 
 
 type ObjectT struct {
 	Type, Origin string
+	// PosRefNode is the idx of the image/table itself among the nodes of Selec
 	PosRefNode, Scope int
+	// the captions within the nodes of Selec are left out
+	NoCaption bool
 	Nodes []*html.Node
+	// the image/table, possibly along with its caption, in the order of the document
 	Selec *goquery.Selection
 }
 
@@ -27,20 +34,147 @@ type ObjectT struct {
 /*
 UsingRef			<table> (NODE) || <div> title (NODE)
 FromSuperior			<table> (NODE) || <img> (NODE)
-InlineCaptionInspector		<div> caption (NODE)
-InIMGCaptionInspector		<img> (NODE)
+CaptionInspector		<img> || <table> (NODE) + caption <p> (NODE)
 */
 
 func (Object ObjectT) Fmt() (str string) {
 	str = fmt.Sprintf("<section class=\"capillary\" origin=\"%s\" scope=\"%d\">", Object.Origin, Object.Scope)
-	tmp, _ := goquery.OuterHtml(Object.Selec)
-	str += tmp
+	Object.Selec.Each(func(i int, s *goquery.Selection) {
+		if Object.NoCaption {
+			s = s.Clone()
+			s.Find(captionSelector).Remove()
+		}
+		tmp, _ := goquery.OuterHtml(s)
+		str += tmp
+	})
 	str += "</section>\n\n"
 	return
 }
 
 
+func compileCaptionPatterns(m *meta.Meta) (captions []*regexp.Regexp, err error) {
+	for _, pattern := range m.Config.CaptionPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid caption pattern %q: %w", pattern, err)
+		}
+		captions = append(captions, re)
+	}
+	return
+}
 
+// CaptionInspector gathers the images and tables of the section of the heading at scope, 0
+// being the whole document, whose caption is a paragraph next to them, along with that caption.
+func (Note NoteType) CaptionInspector(tStack []*html.Node, _ []string, c capillary) (ObjectSlice []ObjectT) {
+	fstr, scope := c.Name, c.Scope
+	region := Note.Section.Ancestor(scope)
+	if scope == 0 || region == nil {
+		region = Note.Section.tree.Root
+	}
+	for _, section := range append([]*Section{region}, region.Descendants()...) {
+		for _, n := range section.Notes {
+			s := goquery.Selection{Nodes: []*html.Node{n}}
+			s.FindMatcher(c.collect).Each(func(i int, selec *goquery.Selection) {
+				// properly captioned or part of a bigger table
+				if selec.ParentsFiltered("figure, table, div.thumb, li.gallerybox").Length() != 0 {
+					return
+				}
+				block := blockOf(selec.Nodes[0], n)
+				if caption := Note.looseCaption(block); caption != nil {
+					obj := ObjectT{
+						Type: selec.Nodes[0].Data,
+						Origin: fstr,
+						Scope: scope,
+						Selec: &goquery.Selection{Nodes: []*html.Node{block, caption}},
+					}
+					if nextElement(caption) == block {
+						obj.Selec.Nodes = []*html.Node{caption, block}
+						obj.PosRefNode = 1
+					}
+					ObjectSlice = append(ObjectSlice, obj)
+				} else if p := block.Parent; p != n && Note.isCaption(Text(p)) {
+					// the caption shares the paragraph of the image
+					ObjectSlice = append(ObjectSlice, ObjectT{
+						Type: selec.Nodes[0].Data,
+						Origin: fstr,
+						Scope: scope,
+						Selec: &goquery.Selection{Nodes: []*html.Node{p}},
+					})
+				}
+			})
+		}
+	}
+	return
+}
 
+// blockOf returns the outermost element around n, up to the cutpattern, that holds nothing but n
+// (e.g. the <p> in which an image was put alone).
+func blockOf(n, cutpattern *html.Node) *html.Node {
+	for n.Parent != nil && n.Parent != cutpattern {
+		for c := n.Parent.FirstChild; c != nil; c = c.NextSibling {
+			if c == n {
+				continue
+			}
+			if c.Type == html.ElementNode || (c.Type == html.TextNode && strings.TrimSpace(c.Data) != "") {
+				return n
+			}
+		}
+		n = n.Parent
+	}
+	return n
+}
 
+// looseCaption returns the element next to block, after or else before it, that is its caption
+func (Note NoteType) looseCaption(block *html.Node) *html.Node {
+	for _, c := range []*html.Node{nextElement(block), prevElement(block)} {
+		if c == nil || isHeading(c) {
+			continue
+		}
+		s := goquery.Selection{Nodes: []*html.Node{c}}
+		if s.Find("img, table").Length() != 0 || c.Data == "img" || c.Data == "table" {
+			continue
+		}
+		if Note.isCaption(Text(c)) {
+			return c
+		}
+	}
+	return nil
+}
+
+func (Note NoteType) isCaption(text string) bool {
+	if Note.refs != nil {
+		if _, ok := captionRef(Note.refs, text); ok {
+			return true
+		}
+	}
+	for _, re := range Note.captions {
+		if re.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
 
+func nextElement(n *html.Node) *html.Node {
+	for c := n.NextSibling; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode {
+			return c
+		}
+		if c.Type == html.TextNode && strings.TrimSpace(c.Data) != "" {
+			return nil
+		}
+	}
+	return nil
+}
+
+func prevElement(n *html.Node) *html.Node {
+	for c := n.PrevSibling; c != nil; c = c.PrevSibling {
+		if c.Type == html.ElementNode {
+			return c
+		}
+		if c.Type == html.TextNode && strings.TrimSpace(c.Data) != "" {
+			return nil
+		}
+	}
+	return nil
+}
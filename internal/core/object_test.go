@@ -0,0 +1,212 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"github.com/tassa-yoniso-manasi-karoto/irgen/internal/meta"
+)
+
+func TestIsCaption(t *testing.T) {
+	tests := []struct {
+		name, text	string
+		patterns	[]string
+		noLabels	bool
+		want		bool
+	}{
+		{name: "figure label", text: "Figure 3: the ulna", want: true},
+		{name: "table label", text: "Tab. 2 Bones of the arm", want: true},
+		{name: "label inside the text", text: "The ulna, as in Figure 3", want: false},
+		{name: "no number", text: "Figure: the ulna", want: false},
+		{name: "pattern", text: "Plate IV. Birds", patterns: []string{"^Plate [IVX]+"}, want: true},
+		{name: "pattern without labels", text: "Plate IV. Birds", patterns: []string{"^Plate [IVX]+"}, noLabels: true, want: true},
+		{name: "no labels", text: "Figure 3: the ulna", noLabels: true, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testMeta()
+			m.Config.CaptionPatterns = tt.patterns
+			if tt.noLabels {
+				m.Config.FigureLabels, m.Config.TableLabels = nil, nil
+			}
+			captions, err := compileCaptionPatterns(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			note := NoteType{refs: referencePattern(m), captions: captions}
+			if got := note.isCaption(tt.text); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileCaptionPatterns(t *testing.T) {
+	m := testMeta()
+	m.Config.CaptionPatterns = []string{"^Plate [IVX]+", "^Plate ("}
+	if _, err := compileCaptionPatterns(m); err == nil || !strings.Contains(err.Error(), `"^Plate ("`) {
+		t.Errorf("got %v, want an error naming the invalid pattern", err)
+	}
+}
+
+func TestLooseCaption(t *testing.T) {
+	tests := []struct {
+		name, input	string
+		want		string
+	}{
+		{
+			name: "after",
+			input: `<p>Lorem</p><p id="block"><img src="a.png"></p><p>Figure 3: the ulna</p>`,
+			want: "Figure 3: the ulna",
+		},
+		{
+			name: "before",
+			input: `<p>Figure 3: the ulna</p><p id="block"><img src="a.png"></p><p>Lorem</p>`,
+			want: "Figure 3: the ulna",
+		},
+		{
+			name: "after rather than before",
+			input: `<p>Figure 2: the radius</p><p id="block"><img src="a.png"></p><p>Figure 3: the ulna</p>`,
+			want: "Figure 3: the ulna",
+		},
+		{
+			name: "not a caption",
+			input: `<p id="block"><img src="a.png"></p><p>Lorem ipsum, see Figure 3</p>`,
+		},
+		{
+			name: "caption of another image",
+			input: `<p id="block"><img src="a.png"></p><p><img src="b.png"> Figure 3: the ulna</p>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testMeta()
+			note := NoteType{refs: referencePattern(m)}
+			block := bodyOf(t, tt.input).Find("#block").Nodes[0]
+			got := ""
+			if caption := note.looseCaption(block); caption != nil {
+				got = Text(caption)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCaptionInspector(t *testing.T) {
+	tests := []struct {
+		name, input	string
+		want		[]string
+	}{
+		{
+			name: "caption after the image",
+			input: `<p>Lorem</p><p><img src="a.png"></p><p>Figure 3: the ulna</p>`,
+			want: []string{`<p><img src="a.png"/></p><p>Figure 3: the ulna</p>`},
+		},
+		{
+			name: "caption before the image",
+			input: `<p>Figure 3: the ulna</p><p><img src="a.png"></p><p>Lorem</p>`,
+			want: []string{`<p>Figure 3: the ulna</p><p><img src="a.png"/></p>`},
+		},
+		{
+			name: "caption in the paragraph of the image",
+			input: `<p><img src="a.png"/> Figure 3: the ulna</p>`,
+			want: []string{`<p><img src="a.png"/> Figure 3: the ulna</p>`},
+		},
+		{
+			name: "table",
+			input: `<table><tbody><tr><td>ulna</td></tr></tbody></table><p>Table 2: bones</p>`,
+			want: []string{`<table><tbody><tr><td>ulna</td></tr></tbody></table><p>Table 2: bones</p>`},
+		},
+		{
+			name: "already in a figure",
+			input: `<figure><img src="a.png"/><figcaption>Figure 3: the ulna</figcaption></figure><p>Figure 4: the radius</p>`,
+		},
+		{
+			name: "no caption",
+			input: `<p><img src="a.png"></p><p>Lorem ipsum</p>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testMeta()
+			m.Config.Functions = []meta.Function{{Name: "CaptionInspector"}}
+			capillaries, err := compileCapillaries(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := capillaries[0]
+			note := notesOf(t, m, `<h1>A</h1><p>dolor sit amet</p><h2>B</h2>`+tt.input)["A"]
+			var got []string
+			for _, obj := range c.run(note, note.Section.Stack(), nil, c) {
+				str := ""
+				obj.Selec.Each(func(_ int, s *goquery.Selection) {
+					html, _ := goquery.OuterHtml(s)
+					str += html
+				})
+				got = append(got, str)
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNoCaption(t *testing.T) {
+	tests := []struct {
+		name, function, input	string
+		captions		bool
+		want, wantNot		string
+	}{
+		{
+			name: "figcaption kept",
+			function: "FromSuperiorAndDescendants",
+			input: `<figure><img src="a.png"/><figcaption>Figure 3: the ulna</figcaption></figure>`,
+			captions: true,
+			want: `<figure><img src="a.png"/><figcaption>Figure 3: the ulna</figcaption></figure>`,
+		},
+		{
+			name: "figcaption left out",
+			function: "FromSuperiorAndDescendants",
+			input: `<figure><img src="a.png"/><figcaption>Figure 3: the ulna</figcaption></figure>`,
+			want: `<figure><img src="a.png"/></figure>`,
+			wantNot: "the ulna",
+		},
+		{
+			name: "loose caption kept",
+			function: "CaptionInspector",
+			input: `<p><img src="a.png"/></p><p>Figure 3: the ulna</p>`,
+			captions: true,
+			want: `<p><img src="a.png"/></p><p>Figure 3: the ulna</p>`,
+		},
+		{
+			name: "loose caption left out",
+			function: "CaptionInspector",
+			input: `<p><img src="a.png"/></p><p>Figure 3: the ulna</p>`,
+			want: `<p><img src="a.png"/></p>`,
+			wantNot: "the ulna",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testMeta()
+			m.Config.Functions = []meta.Function{{Name: tt.function, Captions: &tt.captions}}
+			capillaries, err := compileCapillaries(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			note := notesOf(t, m, `<h1>A</h1><p>dolor sit amet</p>`+tt.input+`<h2>B</h2><p>Lorem ipsum</p>`)["B"]
+			src, objects := note.MkCxt(capillaries, note.Section.Stack())
+			if objects != 1 || !strings.Contains(src, tt.want) {
+				t.Errorf("got %d objects in %q, want %q", objects, src, tt.want)
+			}
+			if tt.wantNot != "" && strings.Contains(src, tt.wantNot) {
+				t.Errorf("got %q, want it without %q", src, tt.wantNot)
+			}
+		})
+	}
+}
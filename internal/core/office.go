@@ -0,0 +1,669 @@
+package core
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+
+	"github.com/tassa-yoniso-manasi-karoto/irgen/internal/meta"
+)
+
+/*
+Word processors documents are zipped XML. Both readers below walk that XML and
+write back the simple HTML that the rest of the pipeline expects: headings,
+paragraphs, lists, tables and <img> pointing to the media imported along.
+Styling is dropped except for bold, italic, underline and sub/superscript.
+*/
+
+var docx = ReaderType{
+	Name: "DOCX",
+	Exts: []string{".docx"},
+	Read: readDOCX,
+}
+
+var odt = ReaderType{
+	Name: "ODT",
+	Exts: []string{".odt"},
+	Read: readODT,
+}
+
+// xnode is a XML element (or a text node if Name.Local is empty) that,
+// unlike what encoding/xml unmarshals to, retains the order of mixed content
+type xnode struct {
+	Name		xml.Name
+	Attr		[]xml.Attr
+	Children	[]*xnode
+	Text		string
+}
+
+func parseXNode(data []byte) (*xnode, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	root := &xnode{}
+	stack := []*xnode{root}
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		parent := stack[len(stack)-1]
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &xnode{Name: t.Name, Attr: t.Attr}
+			parent.Children = append(parent.Children, n)
+			stack = append(stack, n)
+		case xml.EndElement:
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			parent.Children = append(parent.Children, &xnode{Text: string(t)})
+		}
+	}
+	if len(root.Children) == 0 {
+		return nil, fmt.Errorf("empty or malformed XML")
+	}
+	return root, nil
+}
+
+// attributes are matched on their local name, the prefixes of the formats don't collide
+func (n *xnode) attr(local string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Name.Local == local {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+func (n *xnode) child(local string) *xnode {
+	for _, c := range n.Children {
+		if c.Name.Local == local {
+			return c
+		}
+	}
+	return nil
+}
+
+// find returns the first descendant named local, depth-first
+func (n *xnode) find(local string) *xnode {
+	for _, c := range n.Children {
+		if c.Name.Local == local {
+			return c
+		}
+		if found := c.find(local); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func (n *xnode) text() (s string) {
+	if n.Name.Local == "" {
+		return n.Text
+	}
+	for _, c := range n.Children {
+		s += c.text()
+	}
+	return
+}
+
+// officeWriter accumulates the HTML of the document and the media it refers to
+type officeWriter struct {
+	buf	strings.Builder
+	inList	bool
+	prefix	string
+	files	map[string]*zip.File
+	media	map[string][]byte
+	m	*meta.Meta
+}
+
+func newOfficeWriter(m *meta.Meta, p string, zr *zip.ReadCloser) *officeWriter {
+	w := &officeWriter{
+		prefix: strings.TrimSuffix(filepath.Base(p), filepath.Ext(p)),
+		files: make(map[string]*zip.File),
+		media: make(map[string][]byte),
+		m: m,
+	}
+	for _, f := range zr.File {
+		w.files[f.Name] = f
+	}
+	return w
+}
+
+func (w *officeWriter) listItem(open bool) {
+	if open && !w.inList {
+		w.buf.WriteString("<ul>")
+	} else if !open && w.inList {
+		w.buf.WriteString("</ul>")
+	}
+	w.inList = open
+}
+
+// img imports the media at p inside the package and returns the <img> that replaces it
+func (w *officeWriter) img(p, alt string) string {
+	data, err := readZipFile(w.files, p)
+	if err != nil {
+		w.m.Log.Warn().Err(err).Str("path", p).Msg("img referenced by document not found in package")
+		return ""
+	}
+	filename := mediaFilename(w.prefix, p)
+	w.media[filename] = data
+	return fmt.Sprintf(`<img src="%s" alt="%s">`, html.EscapeString(filename), html.EscapeString(alt))
+}
+
+func (w *officeWriter) source(title string) SourceType {
+	w.listItem(false)
+	if title == "" {
+		title = w.prefix
+	}
+	return SourceType{
+		Chapters: []ChapterType{{
+			Title: title,
+			HTML: []byte("<html><body>" + w.buf.String() + "</body></html>"),
+		}},
+		Media: w.media,
+	}
+}
+
+func wrap(s, tag string) string {
+	return "<" + tag + ">" + s + "</" + tag + ">"
+}
+
+func spanAttr(n *xnode, attr, htmlAttr string) string {
+	if v, ok := n.attr(attr); ok {
+		if i, err := strconv.Atoi(v); err == nil && i > 1 {
+			return fmt.Sprintf(` %s="%d"`, htmlAttr, i)
+		}
+	}
+	return ""
+}
+
+func docTitle(files map[string]*zip.File, name string) string {
+	data, err := readZipFile(files, name)
+	if err != nil {
+		return ""
+	}
+	root, err := parseXNode(data)
+	if err != nil {
+		return ""
+	}
+	if t := root.find("title"); t != nil {
+		return strings.TrimSpace(t.text())
+	}
+	return ""
+}
+
+
+/* ---------------------------------- DOCX ---------------------------------- */
+
+var reHeadingStyle = regexp.MustCompile(`(?i)^heading\s*([1-9])$`)
+
+type docxReader struct {
+	*officeWriter
+	rels		map[string]string // relationship ID → target
+	levels		map[string]int // paragraph style ID → heading level
+}
+
+func readDOCX(m *meta.Meta, p string) (src SourceType, err error) {
+	zr, err := zip.OpenReader(p)
+	if err != nil {
+		return src, fmt.Errorf("couldn't open DOCX: %w", err)
+	}
+	defer zr.Close()
+	r := docxReader{
+		officeWriter: newOfficeWriter(m, p, zr),
+		rels: make(map[string]string),
+		levels: make(map[string]int),
+	}
+	data, err := readZipFile(r.files, "word/document.xml")
+	if err != nil {
+		return
+	}
+	doc, err := parseXNode(data)
+	if err != nil {
+		return src, fmt.Errorf("word/document.xml: %w", err)
+	}
+	if data, err := readZipFile(r.files, "word/_rels/document.xml.rels"); err == nil {
+		rels, err := parseXNode(data)
+		if err == nil && rels.find("Relationships") == nil {
+			err = fmt.Errorf("no Relationships element")
+		}
+		if err != nil {
+			m.Log.Warn().Err(err).Str("path", p).Msg("couldn't parse the relationships of the document, its images and links are lost")
+		} else {
+			for _, rel := range rels.find("Relationships").Children {
+				id, _ := rel.attr("Id")
+				target, _ := rel.attr("Target")
+				if mode, _ := rel.attr("TargetMode"); mode != "External" {
+					// some generators write targets relative to the root of the package
+					if strings.HasPrefix(target, "/") {
+						target = strings.TrimPrefix(target, "/")
+					} else {
+						target = path.Join("word", target)
+					}
+				}
+				r.rels[id] = target
+			}
+		}
+	}
+	if data, err := readZipFile(r.files, "word/styles.xml"); err == nil {
+		if styles, err := parseXNode(data); err == nil && styles.find("styles") != nil {
+			r.readStyles(styles)
+		}
+	}
+	body := doc.find("body")
+	if body == nil {
+		return src, fmt.Errorf("word/document.xml: no body")
+	}
+	r.blocks(body)
+	return r.source(docTitle(r.files, "docProps/core.xml")), nil
+}
+
+// Heading1..6 are the IDs of the builtin styles in English but localized
+// versions of Word use translated IDs, hence the name and outline level
+// are checked too.
+func (r docxReader) readStyles(styles *xnode) {
+	for _, style := range styles.find("styles").Children {
+		if style.Name.Local != "style" {
+			continue
+		}
+		id, _ := style.attr("styleId")
+		var name string
+		if n := style.child("name"); n != nil {
+			name, _ = n.attr("val")
+		}
+		for _, s := range []string{id, name} {
+			if sub := reHeadingStyle.FindStringSubmatch(s); sub != nil {
+				r.levels[id], _ = strconv.Atoi(sub[1])
+			}
+		}
+		if _, ok := r.levels[id]; ok {
+			continue
+		}
+		if lvl := style.find("outlineLvl"); lvl != nil {
+			v, _ := lvl.attr("val")
+			// 9 means body text
+			if i, err := strconv.Atoi(v); err == nil && i < 9 {
+				r.levels[id] = i + 1
+			}
+		}
+	}
+}
+
+func (r docxReader) blocks(parent *xnode) {
+	for _, n := range parent.Children {
+		switch n.Name.Local {
+		case "p":
+			r.paragraph(n)
+		case "tbl":
+			r.listItem(false)
+			r.table(n)
+		case "sdt":
+			if content := n.child("sdtContent"); content != nil {
+				r.blocks(content)
+			}
+		}
+	}
+}
+
+func (r docxReader) paragraph(p *xnode) {
+	level := 0
+	isListItem := false
+	if pPr := p.child("pPr"); pPr != nil {
+		if style := pPr.child("pStyle"); style != nil {
+			id, _ := style.attr("val")
+			level = r.levels[id]
+		}
+		if lvl := pPr.child("outlineLvl"); lvl != nil {
+			v, _ := lvl.attr("val")
+			if i, err := strconv.Atoi(v); err == nil && i < 9 {
+				level = i + 1
+			}
+		}
+		isListItem = pPr.child("numPr") != nil
+	}
+	content := r.inline(p)
+	if strings.TrimSpace(content) == "" {
+		return
+	}
+	switch {
+	case level > 0:
+		r.listItem(false)
+		r.buf.WriteString(wrap(content, fmt.Sprint("h", level)))
+	case isListItem:
+		r.listItem(true)
+		r.buf.WriteString(wrap(content, "li"))
+	default:
+		r.listItem(false)
+		r.buf.WriteString(wrap(content, "p"))
+	}
+}
+
+func (r docxReader) inline(parent *xnode) (s string) {
+	for _, n := range parent.Children {
+		switch n.Name.Local {
+		case "r":
+			s += r.run(n)
+		case "hyperlink":
+			inner := r.inline(n)
+			id, _ := n.attr("id")
+			if target, ok := r.rels[id]; ok {
+				inner = fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(target), inner)
+			}
+			s += inner
+		case "ins", "smartTag", "fldSimple":
+			s += r.inline(n)
+		case "sdt":
+			if content := n.child("sdtContent"); content != nil {
+				s += r.inline(content)
+			}
+		}
+	}
+	return
+}
+
+func (r docxReader) run(run *xnode) (s string) {
+	for _, n := range run.Children {
+		switch n.Name.Local {
+		case "t":
+			s += html.EscapeString(n.text())
+		case "tab":
+			s += "\t"
+		case "br", "cr":
+			s += "<br>"
+		case "drawing", "pict", "object":
+			if blip := n.find("blip"); blip != nil {
+				id, _ := blip.attr("embed")
+				alt := ""
+				if docPr := n.find("docPr"); docPr != nil {
+					alt, _ = docPr.attr("descr")
+				}
+				s += r.img(r.rels[id], alt)
+			} else if imagedata := n.find("imagedata"); imagedata != nil {
+				id, _ := imagedata.attr("id")
+				s += r.img(r.rels[id], "")
+			}
+		}
+	}
+	if s == "" {
+		return
+	}
+	if rPr := run.child("rPr"); rPr != nil {
+		for _, f := range []struct{ prop, tag string }{{"b", "b"}, {"i", "i"}, {"u", "u"}} {
+			if prop := rPr.child(f.prop); prop != nil {
+				if v, _ := prop.attr("val"); v != "0" && v != "false" && v != "none" {
+					s = wrap(s, f.tag)
+				}
+			}
+		}
+		if va := rPr.child("vertAlign"); va != nil {
+			switch v, _ := va.attr("val"); v {
+			case "superscript":
+				s = wrap(s, "sup")
+			case "subscript":
+				s = wrap(s, "sub")
+			}
+		}
+	}
+	return
+}
+
+type docxCell struct {
+	tc	*xnode
+	// position of the cell in the grid of the table and number of grid columns it spans
+	col, span	int
+	// vMerge: "restart" for the first cell of a vertical merge, "continue" for those below
+	merge	string
+}
+
+func (r docxReader) table(tbl *xnode) {
+	var rows [][]docxCell
+	for _, tr := range tbl.Children {
+		if tr.Name.Local != "tr" {
+			continue
+		}
+		var row []docxCell
+		col := 0
+		if trPr := tr.child("trPr"); trPr != nil {
+			if before := trPr.child("gridBefore"); before != nil {
+				v, _ := before.attr("val")
+				col, _ = strconv.Atoi(v)
+			}
+		}
+		for _, tc := range tr.Children {
+			if tc.Name.Local != "tc" {
+				continue
+			}
+			c := docxCell{tc: tc, col: col, span: 1}
+			if tcPr := tc.child("tcPr"); tcPr != nil {
+				if span := tcPr.child("gridSpan"); span != nil {
+					v, _ := span.attr("val")
+					if x, err := strconv.Atoi(v); err == nil && x > 1 {
+						c.span = x
+					}
+				}
+				if vMerge := tcPr.child("vMerge"); vMerge != nil {
+					c.merge = "continue"
+					if v, _ := vMerge.attr("val"); v == "restart" {
+						c.merge = "restart"
+					}
+				}
+			}
+			row = append(row, c)
+			col += c.span
+		}
+		rows = append(rows, row)
+	}
+	continues := func(i, col int) bool {
+		for _, c := range rows[i] {
+			if c.col == col {
+				return c.merge == "continue"
+			}
+		}
+		return false
+	}
+	r.buf.WriteString("<table>")
+	for i, row := range rows {
+		r.buf.WriteString("<tr>")
+		for _, c := range row {
+			// cells merged vertically with the one above are covered by its rowspan
+			if c.merge == "continue" {
+				continue
+			}
+			attrs := ""
+			if c.span > 1 {
+				attrs += ` colspan="` + strconv.Itoa(c.span) + `"`
+			}
+			if c.merge == "restart" {
+				n := 1
+				for i+n < len(rows) && continues(i+n, c.col) {
+					n++
+				}
+				if n > 1 {
+					attrs += ` rowspan="` + strconv.Itoa(n) + `"`
+				}
+			}
+			r.buf.WriteString("<td" + attrs + ">")
+			r.blocks(c.tc)
+			r.listItem(false)
+			r.buf.WriteString("</td>")
+		}
+		r.buf.WriteString("</tr>")
+	}
+	r.buf.WriteString("</table>")
+}
+
+
+/* ---------------------------------- ODT ---------------------------------- */
+
+type odtReader struct {
+	*officeWriter
+	styles map[string][]string // automatic style name → tags it translates to
+}
+
+func readODT(m *meta.Meta, p string) (src SourceType, err error) {
+	zr, err := zip.OpenReader(p)
+	if err != nil {
+		return src, fmt.Errorf("couldn't open ODT: %w", err)
+	}
+	defer zr.Close()
+	r := odtReader{
+		officeWriter: newOfficeWriter(m, p, zr),
+		styles: make(map[string][]string),
+	}
+	data, err := readZipFile(r.files, "content.xml")
+	if err != nil {
+		return
+	}
+	doc, err := parseXNode(data)
+	if err != nil {
+		return src, fmt.Errorf("content.xml: %w", err)
+	}
+	if auto := doc.find("automatic-styles"); auto != nil {
+		r.readStyles(auto)
+	}
+	text := doc.find("text")
+	if text == nil {
+		return src, fmt.Errorf("content.xml: no office:text")
+	}
+	r.blocks(text)
+	return r.source(docTitle(r.files, "meta.xml")), nil
+}
+
+func (r odtReader) readStyles(auto *xnode) {
+	for _, style := range auto.Children {
+		name, _ := style.attr("name")
+		props := style.child("text-properties")
+		if name == "" || props == nil {
+			continue
+		}
+		var tags []string
+		if v, _ := props.attr("font-weight"); v == "bold" {
+			tags = append(tags, "b")
+		}
+		if v, _ := props.attr("font-style"); v == "italic" {
+			tags = append(tags, "i")
+		}
+		if v, _ := props.attr("text-underline-style"); v != "" && v != "none" {
+			tags = append(tags, "u")
+		}
+		if v, _ := props.attr("text-position"); strings.HasPrefix(v, "super") {
+			tags = append(tags, "sup")
+		} else if strings.HasPrefix(v, "sub") {
+			tags = append(tags, "sub")
+		}
+		r.styles[name] = tags
+	}
+}
+
+func (r odtReader) blocks(parent *xnode) {
+	for _, n := range parent.Children {
+		switch n.Name.Local {
+		case "h":
+			level := 1
+			if v, ok := n.attr("outline-level"); ok {
+				level, _ = strconv.Atoi(v)
+			}
+			if content := r.inline(n); strings.TrimSpace(content) != "" {
+				r.buf.WriteString(wrap(content, fmt.Sprint("h", max(level, 1))))
+			}
+		case "p":
+			if content := r.inline(n); strings.TrimSpace(content) != "" {
+				r.buf.WriteString(wrap(content, "p"))
+			}
+		case "list":
+			r.buf.WriteString("<ul>")
+			for _, item := range n.Children {
+				if item.Name.Local == "list-item" || item.Name.Local == "list-header" {
+					r.buf.WriteString("<li>")
+					r.blocks(item)
+					r.buf.WriteString("</li>")
+				}
+			}
+			r.buf.WriteString("</ul>")
+		case "table":
+			r.table(n)
+		case "section", "table-of-content", "index-body":
+			r.blocks(n)
+		}
+	}
+}
+
+func (r odtReader) inline(parent *xnode) (s string) {
+	for _, n := range parent.Children {
+		switch n.Name.Local {
+		case "":
+			s += html.EscapeString(n.Text)
+		case "span":
+			inner := r.inline(n)
+			name, _ := n.attr("style-name")
+			for _, tag := range r.styles[name] {
+				inner = wrap(inner, tag)
+			}
+			s += inner
+		case "a":
+			href, _ := n.attr("href")
+			s += fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(href), r.inline(n))
+		case "s":
+			c := 1
+			if v, ok := n.attr("c"); ok {
+				c, _ = strconv.Atoi(v)
+			}
+			s += strings.Repeat(" ", c)
+		case "tab":
+			s += "\t"
+		case "line-break":
+			s += "<br>"
+		case "frame":
+			if img := n.child("image"); img != nil {
+				href, _ := img.attr("href")
+				alt := ""
+				if t := n.child("title"); t != nil {
+					alt = t.text()
+				}
+				if !strings.Contains(href, "://") {
+					s += r.img(strings.TrimPrefix(href, "./"), alt)
+				}
+			}
+		case "note", "note-citation", "annotation":
+			// footnotes, endnotes and comments are dropped
+		default:
+			s += r.inline(n)
+		}
+	}
+	return
+}
+
+func (r odtReader) table(tbl *xnode) {
+	r.buf.WriteString("<table>")
+	var rows func(*xnode)
+	rows = func(parent *xnode) {
+		for _, tr := range parent.Children {
+			switch tr.Name.Local {
+			case "table-header-rows", "table-rows", "table-row-group":
+				rows(tr)
+			case "table-row":
+				r.buf.WriteString("<tr>")
+				for _, tc := range tr.Children {
+					if tc.Name.Local != "table-cell" {
+						continue
+					}
+					r.buf.WriteString("<td" + spanAttr(tc, "number-columns-spanned", "colspan") + spanAttr(tc, "number-rows-spanned", "rowspan") + ">")
+					r.blocks(tc)
+					r.buf.WriteString("</td>")
+				}
+				r.buf.WriteString("</tr>")
+			}
+		}
+	}
+	rows(tbl)
+	r.buf.WriteString("</table>")
+}
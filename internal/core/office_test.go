@@ -0,0 +1,210 @@
+package core
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	"github.com/tassa-yoniso-manasi-karoto/irgen/internal/meta"
+)
+
+func testMeta() *meta.Meta {
+	m := meta.New()
+	m.Log = zerolog.Nop()
+	return m
+}
+
+// writeZip packs files into a package named name inside a temporary directory
+func writeZip(t *testing.T, name string, files map[string]string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), name)
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+const docxNS = `xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships" xmlns:a="http://schemas.openxmlformats.org/drawingml/2006/main"`
+
+const docxStyles = `<?xml version="1.0"?><w:styles xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">` +
+	`<w:style w:styleId="Heading1"><w:name w:val="heading 1"/></w:style>` +
+	`<w:style w:styleId="Titre2"><w:name w:val="heading 2"/></w:style>` +
+	`</w:styles>`
+
+func docxCellXML(props, text string) string {
+	return `<w:tc><w:tcPr>` + props + `</w:tcPr><w:p><w:r><w:t>` + text + `</w:t></w:r></w:p></w:tc>`
+}
+
+func TestReadDOCX(t *testing.T) {
+	tests := []struct {
+		name, body, rels	string
+		want			string
+		wantMedia		string
+	}{
+		{
+			name: "heading and paragraph",
+			body: `<w:p><w:pPr><w:pStyle w:val="Heading1"/></w:pPr><w:r><w:t>Title</w:t></w:r></w:p>` +
+				`<w:p><w:r><w:t>text</w:t></w:r></w:p>`,
+			want: `<h1>Title</h1><p>text</p>`,
+		},
+		{
+			name: "localized heading style",
+			body: `<w:p><w:pPr><w:pStyle w:val="Titre2"/></w:pPr><w:r><w:t>Partie</w:t></w:r></w:p>`,
+			want: `<h2>Partie</h2>`,
+		},
+		{
+			name: "vertically merged and spanned cells",
+			body: `<w:tbl>` +
+				`<w:tr>` + docxCellXML(`<w:vMerge w:val="restart"/>`, "A1") + docxCellXML("", "B1") + docxCellXML("", "C1") + `</w:tr>` +
+				`<w:tr>` + docxCellXML(`<w:vMerge/>`, "") + docxCellXML("", "B2") + docxCellXML("", "C2") + `</w:tr>` +
+				`<w:tr>` + docxCellXML(`<w:vMerge/>`, "") + docxCellXML(`<w:gridSpan w:val="2"/>`, "B3") + `</w:tr>` +
+				`<w:tr>` + docxCellXML("", "A4") + docxCellXML("", "B4") + docxCellXML("", "C4") + `</w:tr>` +
+				`</w:tbl>`,
+			want: `<table>` +
+				`<tr><td rowspan="3"><p>A1</p></td><td><p>B1</p></td><td><p>C1</p></td></tr>` +
+				`<tr><td><p>B2</p></td><td><p>C2</p></td></tr>` +
+				`<tr><td colspan="2"><p>B3</p></td></tr>` +
+				`<tr><td><p>A4</p></td><td><p>B4</p></td><td><p>C4</p></td></tr>` +
+				`</table>`,
+		},
+		{
+			name: "image with a target relative to word/",
+			body: `<w:p><w:r><w:drawing><a:blip r:embed="rId5"/></w:drawing></w:r></w:p>`,
+			rels: `<Relationship Id="rId5" Type="image" Target="media/image1.png"/>`,
+			want: `<p><img src="doc_word_media_image1.png" alt=""></p>`,
+			wantMedia: "doc_word_media_image1.png",
+		},
+		{
+			name: "image with a target absolute in the package",
+			body: `<w:p><w:r><w:drawing><a:blip r:embed="rId5"/></w:drawing></w:r></w:p>`,
+			rels: `<Relationship Id="rId5" Type="image" Target="/word/media/image1.png"/>`,
+			want: `<p><img src="doc_word_media_image1.png" alt=""></p>`,
+			wantMedia: "doc_word_media_image1.png",
+		},
+		{
+			name: "unparsable relationships lose the image only",
+			body: `<w:p><w:r><w:t>before</w:t></w:r><w:r><w:drawing><a:blip r:embed="rId5"/></w:drawing></w:r></w:p>`,
+			rels: `<<<`,
+			want: `<p>before</p>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{
+				"[Content_Types].xml":	`<Types/>`,
+				"word/document.xml":	`<?xml version="1.0"?><w:document ` + docxNS + `><w:body>` + tt.body + `</w:body></w:document>`,
+				"word/styles.xml":	docxStyles,
+				"word/media/image1.png":	"png",
+			}
+			if tt.rels != "" {
+				files["word/_rels/document.xml.rels"] = `<?xml version="1.0"?><Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` + tt.rels + `</Relationships>`
+			}
+			src, err := readDOCX(testMeta(), writeZip(t, "doc.docx", files))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(src.Chapters) != 1 {
+				t.Fatalf("got %d chapters, want 1", len(src.Chapters))
+			}
+			want := "<html><body>" + tt.want + "</body></html>"
+			if got := string(src.Chapters[0].HTML); got != want {
+				t.Errorf("got\n%s\nwant\n%s", got, want)
+			}
+			if tt.wantMedia != "" {
+				if _, ok := src.Media[tt.wantMedia]; !ok {
+					t.Errorf("media %q not imported, got %v", tt.wantMedia, src.Media)
+				}
+			} else if len(src.Media) != 0 {
+				t.Errorf("unexpected media %v", src.Media)
+			}
+		})
+	}
+}
+
+const odtNS = `xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:text="urn:oasis:names:tc:opendocument:xmlns:text:1.0" xmlns:table="urn:oasis:names:tc:opendocument:xmlns:table:1.0" xmlns:draw="urn:oasis:names:tc:opendocument:xmlns:drawing:1.0" xmlns:xlink="http://www.w3.org/1999/xlink" xmlns:dc="http://purl.org/dc/elements/1.1/"`
+
+func TestReadODT(t *testing.T) {
+	tests := []struct {
+		name, body	string
+		want		string
+		wantMedia	string
+	}{
+		{
+			name: "headings and paragraphs",
+			body: `<text:h text:outline-level="2">Part</text:h><text:p>one<text:s text:c="2"/>two</text:p><text:p> </text:p>`,
+			want: `<h2>Part</h2><p>one  two</p>`,
+		},
+		{
+			name: "list",
+			body: `<text:list><text:list-item><text:p>a</text:p></text:list-item><text:list-item><text:p>b</text:p></text:list-item></text:list>`,
+			want: `<ul><li><p>a</p></li><li><p>b</p></li></ul>`,
+		},
+		{
+			name: "spanned cells",
+			body: `<table:table><table:table-row>` +
+				`<table:table-cell table:number-columns-spanned="2"><text:p>A</text:p></table:table-cell>` +
+				`<table:covered-table-cell/>` +
+				`</table:table-row><table:table-row>` +
+				`<table:table-cell table:number-rows-spanned="1"><text:p>B</text:p></table:table-cell>` +
+				`<table:table-cell><text:p>C</text:p></table:table-cell>` +
+				`</table:table-row></table:table>`,
+			want: `<table><tr><td colspan="2"><p>A</p></td></tr><tr><td><p>B</p></td><td><p>C</p></td></tr></table>`,
+		},
+		{
+			name: "comment and footnote",
+			body: `<text:p>The ulna<office:annotation office:name="c1"><dc:creator>Ann</dc:creator><dc:date>2024-01-01T00:00:00</dc:date>` +
+				`<text:p>check this</text:p></office:annotation> is a bone<office:annotation-end office:name="c1"/>` +
+				`<text:note text:note-class="footnote"><text:note-citation>1</text:note-citation><text:note-body><text:p>Gray</text:p></text:note-body></text:note>.</text:p>`,
+			want: `<p>The ulna is a bone.</p>`,
+		},
+		{
+			name: "embedded image",
+			body: `<text:p><draw:frame><draw:image xlink:href="Pictures/img.png"/></draw:frame></text:p>`,
+			want: `<p><img src="doc_Pictures_img.png" alt=""></p>`,
+			wantMedia: "doc_Pictures_img.png",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{
+				"mimetype":	"application/vnd.oasis.opendocument.text",
+				"content.xml":	`<?xml version="1.0"?><office:document-content ` + odtNS + `><office:body><office:text>` + tt.body + `</office:text></office:body></office:document-content>`,
+				"Pictures/img.png":	"png",
+			}
+			src, err := readODT(testMeta(), writeZip(t, "doc.odt", files))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(src.Chapters) != 1 {
+				t.Fatalf("got %d chapters, want 1", len(src.Chapters))
+			}
+			want := "<html><body>" + tt.want + "</body></html>"
+			if got := string(src.Chapters[0].HTML); got != want {
+				t.Errorf("got\n%s\nwant\n%s", got, want)
+			}
+			if tt.wantMedia != "" {
+				if _, ok := src.Media[tt.wantMedia]; !ok {
+					t.Errorf("media %q not imported, got %v", tt.wantMedia, src.Media)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"github.com/tassa-yoniso-manasi-karoto/irgen/internal/common"
+)
+
+/*
+Before importing hundreds of notes, the outline shows the notes that would be made
+and the dry run tells what their import would change in Anki:
+	add	no note of the same ID (the Title field) is in the deck
+	update	one is, but with another RealTitle or Context, and Update is set: only these
+		two fields are rewritten, the Text being what the user works on during
+		incremental reading
+	skip	one is, either unchanged or left as it is since Update isn't set
+*/
+
+type noteAction int
+
+const (
+	actionAdd noteAction = iota
+	actionUpdate
+	actionSkip
+)
+
+func (a noteAction) String() string {
+	return [...]string{"add", "update", "skip"}[a]
+}
+
+// replaced in the tests, which have no Anki to query
+var findNotes = common.FindNotes
+
+type plannedNote struct {
+	Action	noteAction
+	// of the note already in the deck, if any
+	NoteID	int64
+}
+
+// planImport compares the notes with those of the same ID already in the deck.
+func (j *Job) planImport(Notes []NoteType) (plan []plannedNote, err error) {
+	query := fmt.Sprintf(`"deck:%s" "note:IR3"`, escapeAnkiSearch(j.deckName))
+	existing, err := findNotes(j.m, query)
+	if err != nil {
+		return
+	}
+	byID := make(map[string]common.NoteInfo)
+	for _, info := range existing {
+		byID[info.Fields[IR3Fields[0]].Value] = info
+	}
+	plan = make([]plannedNote, len(Notes))
+	for i, Note := range Notes {
+		info, ok := byID[Note.ID]
+		if !ok {
+			continue
+		}
+		plan[i].NoteID = info.NoteID
+		plan[i].Action = actionSkip
+		// the Context may have been edited in Anki: only overwritten when asked to
+		unchanged := info.Fields[IR3Fields[1]].Value == Note.Title && info.Fields[IR3Fields[3]].Value == Note.Context
+		if j.m.Config.Update && !unchanged {
+			plan[i].Action = actionUpdate
+		}
+	}
+	return
+}
+
+func (j *Job) reportPlan(Notes []NoteType, plan []plannedNote) {
+	counts := make(map[noteAction]int)
+	for i, Note := range Notes {
+		counts[plan[i].Action] += 1
+		j.m.Log.Info().Str("action", plan[i].Action.String()).Msg(Note.ID)
+	}
+	j.m.Log.Info().
+		Str("deck", j.deckName).
+		Int("add", counts[actionAdd]).
+		Int("update", counts[actionUpdate]).
+		Int("skip", counts[actionSkip]).
+		Msg("Dry run: nothing was written")
+}
+
+// printOutline writes the tree of the sections of the document along with the notes
+// made from each: their Location, RealTitle, number of words and of context objects.
+func (j *Job) printOutline(w io.Writer, Notes []NoteType) {
+	bySection := make(map[*Section][]NoteType)
+	for _, Note := range Notes {
+		bySection[Note.Section] = append(bySection[Note.Section], Note)
+	}
+	fmt.Fprintf(w, "%s → %s (%d notes)\n", j.Article.Name, j.deckName, len(Notes))
+	for _, section := range j.Tree.Sections {
+		indent := strings.Repeat("  ", section.Depth())
+		if section.Heading != nil {
+			fmt.Fprintf(w, "%s%s %s\n", strings.Repeat("  ", section.Depth()-1), strings.TrimSuffix(section.Loc.miniStr(), " §1"), Text(section.Heading))
+		}
+		for _, Note := range bySection[section] {
+			loc := j.LocRegister[Note.QNode.Nodes[0]]
+			fmt.Fprintf(w, "%s%s  %s  (%d words, %d context objects)\n",
+				indent, strings.TrimSpace(loc.miniStr()), plainTitle(Note.Title), countWords(Note.Txt), Note.Objects)
+		}
+	}
+}
+
+// plainTitle strips the markup of a RealTitle, e.g. "Bones: Ulna"
+func plainTitle(title string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(title))
+	if err != nil {
+		return title
+	}
+	return strings.Join(doc.Find(".heading").Map(func(i int, s *goquery.Selection) string {
+		return s.Text()
+	}), ": ")
+}
+
+func countWords(fragment string) int {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(fragment))
+	if err != nil {
+		return 0
+	}
+	return len(strings.Fields(doc.Text()))
+}
+
+// search terms of Anki are between double quotes, in which * and _ are wildcards
+func escapeAnkiSearch(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `*`, `\*`, `_`, `\_`).Replace(s)
+}
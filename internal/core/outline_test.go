@@ -0,0 +1,156 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/rs/zerolog"
+
+	"github.com/tassa-yoniso-manasi-karoto/irgen/internal/common"
+	"github.com/tassa-yoniso-manasi-karoto/irgen/internal/meta"
+)
+
+func TestEscapeAnkiSearch(t *testing.T) {
+	tests := []struct {
+		deck, want	string
+	}{
+		{`Biology`, `Biology`},
+		{`The "Ulna"`, `The \"Ulna\"`},
+		{`Bones*`, `Bones\*`},
+		{`my_deck`, `my\_deck`},
+		{`C:\notes`, `C:\\notes`},
+		// a colon needn't be escaped after that of "deck:", which keeps subdecks working
+		{`Biology::Bones`, `Biology::Bones`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.deck, func(t *testing.T) {
+			if got := escapeAnkiSearch(tt.deck); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// ankiNote makes a note as returned by FindNotes
+func ankiNote(id int64, fields ...string) (info common.NoteInfo) {
+	info.NoteID = id
+	info.Fields = make(map[string]struct {
+		Value string `json:"value"`
+	})
+	for i, value := range fields {
+		info.Fields[IR3Fields[i]] = struct {
+			Value string `json:"value"`
+		}{value}
+	}
+	return
+}
+
+func TestPlanImport(t *testing.T) {
+	existing := []common.NoteInfo{
+		ankiNote(1, "Doc_1 Unchanged", "unchanged", "text edited in Anki", "context"),
+		ankiNote(2, "Doc_2 Retitled", "old title", "text", "context"),
+		ankiNote(3, "Doc_3 Context", "context", "text", "old context"),
+	}
+	Notes := []NoteType{
+		{ID: "Doc_1 Unchanged", Title: "unchanged", Txt: "text", Context: "context"},
+		{ID: "Doc_2 Retitled", Title: "new title", Context: "context"},
+		{ID: "Doc_3 Context", Title: "context", Context: "new context"},
+		{ID: "Doc_4 New", Title: "new"},
+	}
+	tests := []struct {
+		name		string
+		update		bool
+		want		string
+	}{
+		{name: "add only", want: "[{skip 1} {skip 2} {skip 3} {add 0}]"},
+		{name: "update", update: true, want: "[{skip 1} {update 2} {update 3} {add 0}]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var query string
+			findNotes = func(m *meta.Meta, q string) ([]common.NoteInfo, error) {
+				query = q
+				return existing, nil
+			}
+			t.Cleanup(func() { findNotes = common.FindNotes })
+			j := NewJob(testMeta())
+			j.m.Config.Update = tt.update
+			j.deckName = `Bones_"1"`
+			plan, err := j.planImport(Notes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := `"deck:Bones\_\"1\"" "note:IR3"`; query != want {
+				t.Errorf("got query %s, want %s", query, want)
+			}
+			if got := fmt.Sprint(plan); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanImportError(t *testing.T) {
+	findNotes = func(m *meta.Meta, q string) ([]common.NoteInfo, error) {
+		return nil, errors.New("connection refused")
+	}
+	t.Cleanup(func() { findNotes = common.FindNotes })
+	if _, err := NewJob(testMeta()).planImport([]NoteType{{ID: "Doc_1"}}); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestReportPlan(t *testing.T) {
+	var buf bytes.Buffer
+	j := NewJob(testMeta())
+	j.m.Log = zerolog.New(&buf)
+	j.deckName = "Bones"
+	Notes := []NoteType{{ID: "Doc_1"}, {ID: "Doc_2"}, {ID: "Doc_3"}}
+	j.reportPlan(Notes, []plannedNote{{Action: actionAdd}, {Action: actionSkip, NoteID: 2}, {Action: actionAdd}})
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	want := []string{
+		`{"level":"info","action":"add","message":"Doc_1"}`,
+		`{"level":"info","action":"skip","message":"Doc_2"}`,
+		`{"level":"info","action":"add","message":"Doc_3"}`,
+		`{"level":"info","deck":"Bones","add":2,"update":0,"skip":1,"message":"Dry run: nothing was written"}`,
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestPrintOutline(t *testing.T) {
+	m := testMeta()
+	j := NewJob(m)
+	j.Article.Name, j.deckName = "Arm", "Bones"
+	doc := splitDoc(t, `<p>lead</p><h1>Bones</h1><p>one two three</p><h2>Ulna</h2><p>four five</p><h2>Radius</h2><p>six</p>`)
+	j.LocRegister, j.Tree = Preprocess(m, doc)
+	var Notes []NoteType
+	doc.Find("cutpattern").Each(func(_ int, s *goquery.Selection) {
+		section := j.Tree.Of(s.Nodes[0])
+		Notes = append(Notes, NoteType{
+			QNode: s,
+			Title: j.fmtTl(section.Stack(), 3),
+			Txt: InnerHTML(s.Nodes[0]),
+			Section: section,
+			Objects: len(Notes),
+		})
+	})
+	var buf bytes.Buffer
+	j.printOutline(&buf, Notes)
+	want := "Arm → Bones (4 notes)\n" +
+		"§1  Arm  (1 words, 0 context objects)\n" +
+		"1 Bones\n" +
+		"  1 §1  Bones  (3 words, 1 context objects)\n" +
+		"  1.1 Ulna\n" +
+		"    1.1 §1  Bones: Ulna  (2 words, 2 context objects)\n" +
+		"  1.2 Radius\n" +
+		"    1.2 §1  Bones: Radius  (1 words, 3 context objects)\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
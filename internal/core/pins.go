@@ -0,0 +1,266 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+
+	"github.com/tassa-yoniso-manasi-karoto/irgen/internal/meta"
+)
+
+/*
+High-value images, such as an overview diagram of a whole chapter, can be pinned to a
+heading subtree: they are then part of the context of every note below it, whatever
+the scopes of the capillaries. They are pinned by the Pins of the config, which
+choosePins lets the user fill by picking the images from a preview of the document,
+or in the document itself with the attribute data-irgen-pin, whose value, if any, is
+the pattern of the heading paths (see filter.go) of the sections pinned to.
+*/
+
+const pinAttr = "data-irgen-pin"
+
+// shared by the inputs of a run, a reader of its own for each would lose what the
+// previous one buffered
+var stdin = bufio.NewReader(os.Stdin)
+
+type pinned struct {
+	Selec	*goquery.Selection
+	// the section the image is in, to whose subtree it is pinned when there are no patterns
+	home	*Section
+	under	[]pathPattern
+}
+
+// pinnedIn finds the pinned images of the notes of tree
+func pinnedIn(m *meta.Meta, tree *SectionTree) (pins []pinned, err error) {
+	type rule struct {
+		match	func(*goquery.Selection) bool
+		under	[]pathPattern
+	}
+	var rules []rule
+	for _, p := range m.Config.Pins {
+		var under []pathPattern
+		if p.Under != "" {
+			if under, err = compilePatterns([]string{p.Under}); err != nil {
+				return
+			}
+		}
+		switch {
+		case p.Selector != "":
+			// goquery silently matches nothing with an invalid selector
+			var sel cascadia.Selector
+			if sel, err = cascadia.Compile(p.Selector); err != nil {
+				return nil, fmt.Errorf("invalid pin selector %q: %w", p.Selector, err)
+			}
+			rules = append(rules, rule{func(s *goquery.Selection) bool { return s.IsMatcher(sel) }, under})
+		case p.File != "":
+			file := p.File
+			rules = append(rules, rule{func(s *goquery.Selection) bool { return s.Is("img") && imgFile(s) == file }, under})
+		default:
+			return nil, fmt.Errorf("pin under %q has neither selector nor file", p.Under)
+		}
+	}
+	seen := make(map[*html.Node]bool)
+	add := func(s *goquery.Selection, home *Section, under []pathPattern) {
+		if p := s.ParentsFiltered("figure, div.tmulti, div.thumb"); p.Length() != 0 {
+			s = p.Last()
+		}
+		if seen[s.Nodes[0]] {
+			return
+		}
+		seen[s.Nodes[0]] = true
+		pins = append(pins, pinned{s, home, under})
+	}
+	for _, section := range tree.Sections {
+		for _, n := range section.Notes {
+			note := goquery.Selection{Nodes: []*html.Node{n}}
+			note.Find("*").EachWithBreak(func(i int, s *goquery.Selection) bool {
+				if pattern, ok := s.Attr(pinAttr); ok {
+					var under []pathPattern
+					if pattern != "" {
+						var e error
+						if under, e = compilePatterns([]string{pattern}); e != nil {
+							err = fmt.Errorf("%s of an image of %s: %w", pinAttr, sectionName(section), e)
+							return false
+						}
+					}
+					add(s, section, under)
+					return true
+				}
+				for _, r := range rules {
+					if r.match(s) {
+						add(s, section, r.under)
+						return true
+					}
+				}
+				return true
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(pins) != 0 {
+		m.Log.Debug().Int("pins", len(pins)).Msg("Images pinned")
+	}
+	return
+}
+
+// covers tells whether the notes of the section get the pinned image
+func (p pinned) covers(s *Section) bool {
+	if len(p.under) != 0 {
+		return matchesAny(p.under, s.Path())
+	}
+	return s.Depth() >= p.home.Depth() && s.Ancestor(s.Depth()-p.home.Depth()) == p.home
+}
+
+// PinnedCxt returns the pinned images that the note is below
+func (Note NoteType) PinnedCxt(pins []pinned) (src string, objects int) {
+	for _, p := range pins {
+		if !p.covers(Note.Section) {
+			continue
+		}
+		main, _ := goquery.OuterHtml(p.Selec)
+		if strings.Contains(Note.Txt, main) || strings.Contains(Note.Context, main) || strings.Contains(src, main) {
+			continue
+		}
+		obj := ObjectT{
+			Type: p.Selec.Nodes[0].Data,
+			Origin: "Pinned",
+			Selec: p.Selec,
+		}
+		src += obj.Fmt()
+		objects += 1
+	}
+	return
+}
+
+// the name of the file of the img, e.g. "overview.png"
+func imgFile(s *goquery.Selection) string {
+	src := s.AttrOr("src", "")
+	if u, err := url.Parse(src); err == nil {
+		src = u.Path
+	}
+	name, err := url.PathUnescape(path.Base(src))
+	if err != nil {
+		return path.Base(src)
+	}
+	return name
+}
+
+type pinCandidate struct {
+	section	*Section
+	file, alt	string
+}
+
+// choosePins previews the images of the document section by section and saves those
+// the user picks in the Pins of config.json, each pinned under the pattern given or,
+// by default, to the section it is in.
+func (j *Job) choosePins(in *bufio.Reader, out io.Writer) error {
+	m := j.m
+	var candidates []pinCandidate
+	for _, section := range j.Tree.Sections {
+		for _, n := range section.Notes {
+			note := goquery.Selection{Nodes: []*html.Node{n}}
+			note.Find("img").Each(func(i int, s *goquery.Selection) {
+				file := imgFile(s)
+				if file == "" || file == "." || slices.ContainsFunc(m.Config.Pins, func(p meta.Pin) bool { return p.File == file }) {
+					return
+				}
+				candidates = append(candidates, pinCandidate{section, file, s.AttrOr("alt", "")})
+			})
+		}
+	}
+	if len(candidates) == 0 {
+		fmt.Fprintf(out, "%s: no image to pin\n", j.Article.Name)
+		return nil
+	}
+	fmt.Fprintf(out, "%s: %d images\n", j.Article.Name, len(candidates))
+	for i, c := range candidates {
+		fmt.Fprintf(out, "%4d  %s  %s  %s\n", i+1, sectionName(c.section), c.file, c.alt)
+	}
+	fmt.Fprint(out, "Images to pin, e.g. \"1 3-5\" (none if empty): ")
+	line, err := readLine(in)
+	if err != nil {
+		return err
+	}
+	chosen, err := parseChoice(line, len(candidates))
+	if err != nil {
+		return err
+	}
+	var pins []meta.Pin
+	for _, i := range chosen {
+		c := candidates[i-1]
+		fmt.Fprintf(out, "Pin %s under the headings matching (empty for %s and its subsections): ", c.file, sectionName(c.section))
+		under, err := readLine(in)
+		if err != nil {
+			return err
+		}
+		if under != "" {
+			if _, err := compilePatterns([]string{under}); err != nil {
+				return err
+			}
+		}
+		pins = append(pins, meta.Pin{Under: under, File: c.file})
+	}
+	if len(pins) == 0 {
+		return nil
+	}
+	if err := m.SavePins(pins); err != nil {
+		return fmt.Errorf("couldn't save the pins: %w", err)
+	}
+	fmt.Fprintf(out, "%d images pinned in config.json\n", len(pins))
+	return nil
+}
+
+func sectionName(s *Section) string {
+	if s.Heading == nil {
+		return "(lead)"
+	}
+	return strings.Join(s.Path(), "/")
+}
+
+func readLine(in *bufio.Reader) (string, error) {
+	line, err := in.ReadString('\n')
+	// the last line may have no newline, or there may be none left
+	if err == io.EOF {
+		err = nil
+	}
+	return strings.TrimSpace(line), err
+}
+
+// parseChoice parses numbers and ranges of numbers from 1 to max, e.g. "1 3-5, 8"
+func parseChoice(s string, max int) (chosen []int, err error) {
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		first, last, isRange := strings.Cut(field, "-")
+		from, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("invalid choice %q", field)
+		}
+		to := from
+		if isRange {
+			if to, err = strconv.Atoi(last); err != nil {
+				return nil, fmt.Errorf("invalid choice %q", field)
+			}
+		}
+		if from < 1 || to > max || from > to {
+			return nil, fmt.Errorf("choice %q out of 1-%d", field, max)
+		}
+		for i := from; i <= to; i++ {
+			if !slices.Contains(chosen, i) {
+				chosen = append(chosen, i)
+			}
+		}
+	}
+	return
+}
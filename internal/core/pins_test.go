@@ -0,0 +1,180 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/tassa-yoniso-manasi-karoto/irgen/internal/meta"
+)
+
+func TestParseChoice(t *testing.T) {
+	tests := []struct {
+		input	string
+		want	string
+		wantErr	bool
+	}{
+		{input: "", want: "[]"},
+		{input: "1 3-5, 8", want: "[1 3 4 5 8]"},
+		{input: "2,2 1-2", want: "[2 1]"},
+		{input: "0", wantErr: true},
+		{input: "9", wantErr: true},
+		{input: "5-3", wantErr: true},
+		{input: "a", wantErr: true},
+		{input: "1-b", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseChoice(tt.input, 8)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(got) != tt.want {
+				t.Errorf("got %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChoosePins(t *testing.T) {
+	const doc = `<p><img src="lead.png"></p>` +
+		`<h1>Bones</h1><p><img src="img/skeleton.png" alt="skeleton"><img src="pinned.png"></p>` +
+		`<h2>Ulna</h2><p><img src="ulna%20bone.png"></p>`
+	tests := []struct {
+		name, input	string
+		want		[]meta.Pin
+		wantErr		bool
+	}{
+		{
+			name: "none",
+			input: "\n",
+		},
+		{
+			name: "under the section or a pattern",
+			input: "2-3\n\nBones/**\n",
+			want: []meta.Pin{{File: "pinned.png"}, {File: "skeleton.png"}, {Under: "Bones/**", File: "ulna bone.png"}},
+		},
+		{
+			name: "no newline at the end",
+			input: "1\nre:^Bones",
+			want: []meta.Pin{{File: "pinned.png"}, {Under: "re:^Bones", File: "lead.png"}},
+		},
+		{
+			name: "invalid pattern",
+			input: "1\nBones/[a-\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wd, _ := os.Getwd()
+			if err := os.Chdir(t.TempDir()); err != nil {
+				t.Fatal(err)
+			}
+			defer os.Chdir(wd)
+			m := testMeta()
+			m.Config.Pins = []meta.Pin{{File: "pinned.png"}}
+			if err := m.SavePins(m.Config.Pins); err != nil {
+				t.Fatal(err)
+			}
+			j := NewJob(m)
+			_, j.Tree = Preprocess(m, splitDoc(t, doc))
+			var out strings.Builder
+			err := j.choosePins(bufio.NewReader(strings.NewReader(tt.input)), &out)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(out.String(), "3 images") {
+				t.Errorf("already pinned image listed:\n%s", out.String())
+			}
+			if tt.want == nil {
+				tt.want = m.Config.Pins
+			}
+			saved := testMeta()
+			if err := saved.LoadConfig(); err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(saved.Config.Pins) != fmt.Sprint(tt.want) {
+				t.Errorf("got pins %+v, want %+v", saved.Config.Pins, tt.want)
+			}
+		})
+	}
+}
+
+func TestPinnedIn(t *testing.T) {
+	tests := []struct {
+		name, input	string
+		pins		[]meta.Pin
+		want		string // the images pinned and the sections they cover
+		wantErr		string
+	}{
+		{
+			name: "attribute, without and with a pattern",
+			input: `<h1>A</h1><p><img src="a.png" data-irgen-pin></p><h2>A1</h2><p>x</p>` +
+				`<h1>B</h1><figure data-irgen-pin="A/**"><img src="b.png"></figure>`,
+			want: "a.png: A A/A1|b.png: A A/A1",
+		},
+		{
+			name: "config, by file or selector",
+			input: `<h1>A</h1><p><img src="a.png"></p><h1>B</h1><p><img id="map" src="b.png"></p>`,
+			pins: []meta.Pin{{File: "a.png", Under: "B"}, {Selector: "#map"}},
+			want: "a.png: B|b.png: B",
+		},
+		{
+			name: "invalid pattern followed by a valid one",
+			input: `<h1>A</h1><p><img src="a.png" data-irgen-pin="A/[b-"></p>` +
+				`<h1>B</h1><p><img src="b.png" data-irgen-pin="B"></p>`,
+			wantErr: `"A/[b-"`,
+		},
+		{
+			name: "invalid pattern in the config",
+			input: `<h1>A</h1><p><img src="a.png"></p>`,
+			pins: []meta.Pin{{File: "a.png", Under: "re:("}},
+			wantErr: `"re:("`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testMeta()
+			m.Config.Pins = tt.pins
+			_, tree := Preprocess(m, splitDoc(t, tt.input))
+			pins, err := pinnedIn(m, tree)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one naming %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, p := range pins {
+				var covered []string
+				for _, s := range tree.Sections[1:] {
+					if p.covers(s) {
+						covered = append(covered, strings.Join(s.Path(), "/"))
+					}
+				}
+				got = append(got, imgFile(p.Selec.Find("img").AddBackFiltered("img"))+": "+strings.Join(covered, " "))
+			}
+			if strings.Join(got, "|") != tt.want {
+				t.Errorf("got %q, want %q", strings.Join(got, "|"), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+
+	"github.com/tassa-yoniso-manasi-karoto/irgen/internal/meta"
+)
+
+// A ReaderType opens a local document that goquery can't digest as is
+// (e.g. an .epub) and turns it into HTML chapters, along with the media
+// files that are packaged inside it.
+type ReaderType struct {
+	Name	string
+	Exts	[]string
+	// whether a directory holding such files can be read as a single document
+	Folders	bool
+	Read	func(*meta.Meta, string) (SourceType, error)
+}
+
+type SourceType struct {
+	Chapters	[]ChapterType // in reading order
+	// filename in collection.media → content of the file
+	Media		map[string][]byte
+	// filename in collection.media → URL to download the file from
+	Downloads	map[string]string
+}
+
+type ChapterType struct {
+	Title	string
+	HTML	[]byte
+}
+
+var (
+	readers = []ReaderType{epub, docx, odt, markdown, mhtml}
+	reUnsafeFilename = regexp.MustCompile(`[\\/:*?"<>|\s]+`)
+)
+
+func readerFor(path string) (ReaderType, bool) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return folderReaderFor(path)
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, reader := range readers {
+		if contains(reader.Exts, ext) {
+			return reader, true
+		}
+	}
+	return ReaderType{}, false
+}
+
+func folderReaderFor(dir string) (found ReaderType, ok bool) {
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		for _, reader := range readers {
+			if reader.Folders && contains(reader.Exts, strings.ToLower(filepath.Ext(path))) {
+				found, ok = reader, true
+				return fs.SkipAll
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// Join puts the body of every chapter back to back into a single HTML document.
+func (src SourceType) Join() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("<html><body>")
+	for _, chapter := range src.Chapters {
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(chapter.HTML))
+		if err != nil {
+			continue
+		}
+		inner, _ := doc.Find("body").Html()
+		buf.WriteString(inner)
+	}
+	buf.WriteString("</body></html>")
+	return buf.Bytes()
+}
+
+// JoinAsBook is like Join but puts each chapter under a <h1> of its title, the
+// headings of the chapter being moved down to make room for it.
+func (src SourceType) JoinAsBook() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("<html><body>")
+	for i, chapter := range src.Chapters {
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(chapter.HTML))
+		if err != nil {
+			continue
+		}
+		headings := findHeadings(doc.Selection)
+		top := 0
+		headings.Each(func(i int, s *goquery.Selection) {
+			if x := headingLevel(s.Nodes[0]); top == 0 || x < top {
+				top = x
+			}
+		})
+		headings.Each(func(i int, s *goquery.Selection) {
+			s.Nodes[0].Data = fmt.Sprint("h", headingLevel(s.Nodes[0])-top+2)
+		})
+		title := chapter.Title
+		if title == "" {
+			title = fmt.Sprint("Chapter ", i+1)
+		}
+		buf.WriteString("<h1>" + html.EscapeString(title) + "</h1>")
+		inner, _ := doc.Find("body").Html()
+		buf.WriteString(inner)
+	}
+	buf.WriteString("</body></html>")
+	return buf.Bytes()
+}
+
+// extractor is a variant of the local extractor that imports the media
+// packaged inside the source instead of those lying next to the input file.
+func (src SourceType) extractor(name string) ExtractorType {
+	x := local
+	x.Name = name
+	x.IMGProcessor = func(ctx context.Context, m *meta.Meta, n *goquery.Selection) {
+		importMedia(m, src.Media)
+		if len(src.Downloads) != 0 {
+			downloadMedia(ctx, m, src.Downloads)
+		}
+	}
+	return x
+}
+
+// executeByChapter processes each chapter as a document of its own that goes
+// into a subdeck of the deck of the book.
+func (src SourceType) executeByChapter(ctx context.Context, j *Job) (notes int, success bool) {
+	m := j.m
+	book, bookDeck, bookOutFile := j.Article.Name, j.deckName, j.outFile
+	// the media of the whole source are imported once rather than with each chapter
+	j.Extractor.IMGProcessor(ctx, m, nil)
+	j.Extractor.IMGProcessor = func(context.Context, *meta.Meta, *goquery.Selection) {}
+	success = true
+	for i, chapter := range src.Chapters {
+		title := chapter.Title
+		if title == "" {
+			title = fmt.Sprint("Chapter ", i+1)
+		}
+		j.Article.Name = fmt.Sprint(book, " - ", title)
+		j.deckName = fmt.Sprint(bookDeck, "::", title)
+		j.outFile = fmt.Sprint(strings.TrimSuffix(bookOutFile, ".txt"), " - ", safeFilename(title), ".txt")
+		m.Log.Info().Str("chapter", title).Msg("Processing chapter")
+		n, ok := j.process(ctx, chapter.HTML)
+		notes += n
+		if !ok {
+			success = false
+		}
+	}
+	return
+}
+
+func importMedia(m *meta.Meta, media map[string][]byte) {
+	if len(media) == 0 {
+		return
+	}
+	var total int
+	for filename, data := range media {
+		destPath := filepath.Join(m.Config.CollectionMedia, filename)
+		if _, err := os.Stat(destPath); !errors.Is(err, os.ErrNotExist) {
+			m.Log.Trace().Str("path", destPath).Msg("Img exist already")
+			continue
+		}
+		if m.Config.DryRun {
+			total += 1
+			continue
+		}
+		if err := os.WriteFile(destPath, data, 0644); err != nil {
+			m.Log.Error().Err(err).Str("destPath", destPath).Msg("can't write packaged img to collection.media")
+			continue
+		}
+		total += 1
+	}
+	if m.Config.DryRun {
+		m.Log.Info().Msg(fmt.Sprint("dry run: ", total, " images not imported."))
+		return
+	}
+	m.Log.Info().Msg(fmt.Sprint(total, " images imported."))
+}
+
+// collection.media is flat: media inside a package are prefixed with the name
+// of the package and the directories of their path are flattened.
+func mediaFilename(prefix, p string) string {
+	return safeFilename(prefix + "_" + strings.ReplaceAll(p, "/", "_"))
+}
+
+func safeFilename(s string) string {
+	return reUnsafeFilename.ReplaceAllString(s, "_")
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"github.com/tassa-yoniso-manasi-karoto/irgen/internal/meta"
+)
+
+// A ref is a reference to a figure or a table, e.g. "Fig. 3.2" or "Tab. 3-2", both
+// having the number "3.2". The label tells the kind, whatever the language.
+type ref struct {
+	Kind, Num string
+}
+
+var reRefNumSep = regexp.MustCompile(`[-–‐]`)
+
+// referencePattern compiles the labels of the config into a regex whose 1st group is a
+// figure label, the 2nd a table label and the 3rd the number.
+func referencePattern(m *meta.Meta) *regexp.Regexp {
+	alternation := func(labels []string) string {
+		labels = slices.Clone(labels)
+		// longest first, so that "Figure 2" isn't read as "Fig" followed by garbage
+		slices.SortFunc(labels, func(a, b string) int { return len(b) - len(a) })
+		for i, label := range labels {
+			labels[i] = regexp.QuoteMeta(label)
+		}
+		return strings.Join(labels, "|")
+	}
+	if len(m.Config.FigureLabels) == 0 && len(m.Config.TableLabels) == 0 {
+		return nil
+	}
+	// an empty alternation would match anywhere
+	figure, table := alternation(m.Config.FigureLabels), alternation(m.Config.TableLabels)
+	if figure == "" {
+		figure = `[^\s\S]`
+	}
+	if table == "" {
+		table = `[^\s\S]`
+	}
+	return regexp.MustCompile(`(?i)(?:^|[^\p{L}])(?:(` + figure + `)|(` + table + `))\s*(\d+(?:[.\-–‐]\d+)*)`)
+}
+
+// findRefs returns the refs made in texts, in order and without duplicates
+func findRefs(re *regexp.Regexp, texts ...string) (refs []ref) {
+	for _, text := range texts {
+		for _, sub := range re.FindAllStringSubmatch(text, -1) {
+			r := ref{Kind: "figure", Num: reRefNumSep.ReplaceAllString(sub[3], ".")}
+			if sub[2] != "" {
+				r.Kind = "table"
+			}
+			if !slices.Contains(refs, r) {
+				refs = append(refs, r)
+			}
+		}
+	}
+	return
+}
+
+// captionRef returns the ref the caption starts with, e.g. "Figure 3.2: the ulna"
+func captionRef(re *regexp.Regexp, caption string) (r ref, ok bool) {
+	caption = strings.TrimSpace(caption)
+	sub := re.FindStringSubmatchIndex(caption)
+	// the label must open the caption, else it is a mere reference to another figure
+	if sub == nil || (sub[2] != 0 && sub[4] != 0) {
+		return
+	}
+	refs := findRefs(re, caption[:sub[1]])
+	return refs[0], true
+}
+
+// captionedIn maps the refs to the figures and tables of the notes of sections whose
+// caption starts with them, the first in document order being retained.
+func captionedIn(re *regexp.Regexp, sections []*Section) map[ref]*goquery.Selection {
+	captioned := make(map[ref]*goquery.Selection)
+	for _, section := range sections {
+		for _, n := range section.Notes {
+			s := goquery.Selection{Nodes: []*html.Node{n}}
+			s.Find("div.thumb, li.gallerybox, figure, table").Each(func(i int, selec *goquery.Selection) {
+				caption := selec.ChildrenFiltered("figcaption, caption").First()
+				if caption.Length() == 0 {
+					caption = selec.Find(".thumbcaption, .gallerytext").First()
+				}
+				r, ok := captionRef(re, caption.Text())
+				if !ok {
+					return
+				}
+				if _, found := captioned[r]; !found {
+					captioned[r] = selec
+				}
+			})
+		}
+	}
+	return captioned
+}
@@ -0,0 +1,66 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestFindRefs(t *testing.T) {
+	tests := []struct {
+		name, text	string
+		tableOnly	bool
+		want		string
+	}{
+		{name: "figure and table", text: "see Fig. 3 and Table 2-1", want: "[{figure 3} {table 2.1}]"},
+		{name: "longest label first", text: "Figure 12", want: "[{figure 12}]"},
+		{name: "without space, en dash", text: "(Fig.3–2)", want: "[{figure 3.2}]"},
+		{name: "case and duplicates", text: "fig. 3, then FIG. 3 again", want: "[{figure 3}]"},
+		{name: "other languages", text: "Abb. 4 und Tabelle 5, Tableau 6", want: "[{figure 4} {table 5} {table 6}]"},
+		{name: "inside a word", text: "Configure 3 things", want: "[]"},
+		{name: "no number", text: "the figure below", want: "[]"},
+		{name: "only tables labelled", text: "Fig. 1 and Table 2", tableOnly: true, want: "[{table 2}]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testMeta()
+			if tt.tableOnly {
+				m.Config.FigureLabels = nil
+			}
+			if got := fmt.Sprint(findRefs(referencePattern(m), tt.text)); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReferencePatternWithoutLabels(t *testing.T) {
+	m := testMeta()
+	m.Config.FigureLabels, m.Config.TableLabels = nil, nil
+	if re := referencePattern(m); re != nil {
+		t.Errorf("got %s, want no pattern", re)
+	}
+}
+
+func TestCaptionRef(t *testing.T) {
+	tests := []struct {
+		caption	string
+		want	ref
+		wantOk	bool
+	}{
+		{"Figure 3.2: the ulna", ref{"figure", "3.2"}, true},
+		{"  Tab. 1 Bones of the arm", ref{"table", "1"}, true},
+		{"Abbildung 2-4", ref{"figure", "2.4"}, true},
+		{"The ulna, as in Fig. 2", ref{}, false},
+		{"Figurehead 2", ref{}, false},
+		{"", ref{}, false},
+	}
+	re := referencePattern(testMeta())
+	for _, tt := range tests {
+		t.Run(tt.caption, func(t *testing.T) {
+			got, ok := captionRef(re, tt.caption)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("got %v %v, want %v %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
@@ -0,0 +1,477 @@
+package core
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"github.com/tassa-yoniso-manasi-karoto/irgen/internal/meta"
+	"github.com/tassa-yoniso-manasi-karoto/irgen/internal/common"
+)
+
+// normalizeHeadings renumbers the headings after their depth in the outline of the
+// document, so that skipped levels (an h2 followed by an h5) collapse and that
+// levels shifted by the extractors start at h1.
+func normalizeHeadings(doc *goquery.Document) {
+	var open []int
+	for _, n := range doc.Find("body").Children().Nodes {
+		if !isHeading(n) {
+			continue
+		}
+		level := headingLevel(n)
+		for len(open) != 0 && open[len(open)-1] >= level {
+			open = open[:len(open)-1]
+		}
+		open = append(open, level)
+		n.Data = fmt.Sprint("h", len(open))
+		n.DataAtom = atom.Lookup([]byte(n.Data))
+	}
+}
+
+// cutAtMarkers cuts the sections at the markers authors put in their documents, the
+// comment <!-- irgen:cut --> or the elements matching CutSelector (e.g. "hr"), into
+// consecutive cutpatterns under the same heading, told apart by Location[0].
+// A marker that is found deeper in the section splits the elements wrapping it, unless
+// one of them is a table, a list item, a figure or a <pre>: the marker is then ignored.
+func cutAtMarkers(m *meta.Meta, doc *goquery.Document) {
+	var markers []*html.Node
+	cuts := doc.Find("body > cutpattern")
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.CommentNode && strings.TrimSpace(c.Data) == cutComment {
+				markers = append(markers, c)
+			} else if c.Type == html.ElementNode {
+				walk(c)
+			}
+		}
+	}
+	for _, cut := range cuts.Nodes {
+		walk(cut)
+	}
+	if m.Config.CutSelector != "" {
+		// comments can't be matched by selectors
+		markers = append(markers, cuts.Find(m.Config.CutSelector).Nodes...)
+	}
+	if len(markers) == 0 {
+		return
+	}
+	sortInDocumentOrder(markers)
+	var touched []*html.Node
+	for _, marker := range markers {
+		// markers matched within another marker vanished along with it
+		if !isAttached(marker) {
+			continue
+		}
+		if container := unsplittable(marker); container != nil {
+			m.Log.Warn().
+				Str("in", container.Data).
+				Str("sample", common.StringCapLen(Text(container), 80)).
+				Msg("cut marker ignored: it would split an element that must stay whole")
+			continue
+		}
+		for marker.Parent.Parent != nil && marker.Parent.Parent.Data != "body" {
+			splitAround(marker)
+		}
+		cut := marker.Parent
+		next := newElement("cutpattern")
+		cut.Parent.InsertBefore(next, cut.NextSibling)
+		if rest := marker.NextSibling; rest != nil {
+			moveFrom(rest, next)
+		}
+		// an element that holds content starts the next note, a mere separator goes
+		cut.RemoveChild(marker)
+		if marker.Type == html.ElementNode && marker.Data != "hr" && hasContent(marker) {
+			next.InsertBefore(marker, next.FirstChild)
+		}
+		touched = append(touched, cut, next)
+	}
+	// a marker at the very start or end of a section, e.g. a decorative <hr>, doesn't
+	// make an empty note that would shift the numbering of the next ones
+	for _, cut := range touched {
+		if cut.Parent == nil || hasContent(cut) {
+			continue
+		}
+		if isCutpattern(prevElement(cut)) || isCutpattern(nextElement(cut)) {
+			cut.Parent.RemoveChild(cut)
+		}
+	}
+	m.Log.Debug().Int("markers", len(markers)).Msg("Sections cut at markers")
+}
+
+// unsplittable returns the element around n that a cut would break apart, like a table
+// whose halves would end up in different notes, or nil
+func unsplittable(n *html.Node) *html.Node {
+	for p := n.Parent; p != nil && p.Data != "cutpattern"; p = p.Parent {
+		switch p.Data {
+		case "table", "li", "figure", "pre":
+			return p
+		}
+	}
+	return nil
+}
+
+func isCutpattern(n *html.Node) bool {
+	return n != nil && n.Type == html.ElementNode && n.Data == "cutpattern"
+}
+
+func isAttached(n *html.Node) bool {
+	for ; n.Parent != nil; n = n.Parent {
+		if n.Parent.Type == html.DocumentNode {
+			return true
+		}
+	}
+	return false
+}
+
+const cutComment = "irgen:cut"
+
+// splitAround splits the parent of n in two, n ending up between both halves
+func splitAround(n *html.Node) {
+	parent := n.Parent
+	clone := &html.Node{Type: parent.Type, Data: parent.Data, DataAtom: parent.DataAtom, Namespace: parent.Namespace}
+	for _, a := range parent.Attr {
+		if a.Key != "id" {
+			clone.Attr = append(clone.Attr, a)
+		}
+	}
+	parent.Parent.InsertBefore(clone, parent.NextSibling)
+	if rest := n.NextSibling; rest != nil {
+		moveFrom(rest, clone)
+	}
+	parent.RemoveChild(n)
+	parent.Parent.InsertBefore(n, clone)
+	if clone.FirstChild == nil {
+		clone.Parent.RemoveChild(clone)
+	}
+	if parent.FirstChild == nil {
+		parent.Parent.RemoveChild(parent)
+	}
+}
+
+func hasContent(n *html.Node) bool {
+	s := goquery.Selection{Nodes: []*html.Node{n}}
+	return strings.TrimSpace(s.Text()) != "" || s.Find("img, table, svg, video, audio").Length() != 0
+}
+
+func sortInDocumentOrder(nodes []*html.Node) {
+	rank := make(map[*html.Node]int)
+	var i int
+	root := nodes[0]
+	for root.Parent != nil {
+		root = root.Parent
+	}
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		rank[n] = i
+		i++
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(root)
+	slices.SortFunc(nodes, func(a, b *html.Node) int { return rank[a] - rank[b] })
+}
+
+// splitOversized cuts the sections longer than MaxSectionSize into consecutive
+// cutpatterns under the same heading, between paragraphs or between the items
+// of a list. These are told apart by Location[0] (§1, §2...).
+func splitOversized(m *meta.Meta, doc *goquery.Document) {
+	max := m.Config.MaxSectionSize
+	if max <= 0 {
+		return
+	}
+	size := func(n *html.Node) int {
+		return sizeOf(m, n)
+	}
+	var total int
+	doc.Find("body > cutpattern").Each(func(i int, s *goquery.Selection) {
+		cut := s.Nodes[0]
+		if size(cut) <= max {
+			return
+		}
+		for c := cut.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && (c.Data == "ul" || c.Data == "ol") && size(c) > max {
+				splitList(c, max, size)
+			}
+		}
+		for cut != nil {
+			var rest *html.Node
+			var sum int
+			for c := cut.FirstChild; c != nil; c = c.NextSibling {
+				x := size(c)
+				if sum > 0 && sum+x > max {
+					rest = c
+					break
+				}
+				sum += x
+			}
+			if rest == nil {
+				break
+			}
+			next := newElement("cutpattern")
+			cut.Parent.InsertBefore(next, cut.NextSibling)
+			moveFrom(rest, next)
+			cut = next
+			total++
+		}
+	})
+	if total != 0 {
+		m.Log.Info().Int("cuts", total).Msg(fmt.Sprint("Sections longer than ", max, " ", m.Config.SectionSizeUnit, " were cut"))
+	}
+}
+
+var inlineElements = map[string]bool{
+	"a": true, "abbr": true, "b": true, "bdi": true, "bdo": true, "cite": true,
+	"code": true, "data": true, "dfn": true, "em": true, "font": true, "i": true,
+	"kbd": true, "mark": true, "q": true, "s": true, "samp": true, "small": true,
+	"span": true, "strong": true, "sub": true, "sup": true, "time": true, "u": true,
+	"var": true,
+}
+
+// sizeOf measures the text of n in SectionSizeUnit
+func sizeOf(m *meta.Meta, n *html.Node) int {
+	text := blockText(n)
+	if m.Config.SectionSizeUnit == "characters" {
+		return utf8.RuneCountInString(text)
+	}
+	return len(strings.Fields(text))
+}
+
+// blockText returns the text of n with its whitespace collapsed. Unlike Text, the
+// texts of consecutive blocks (<p>a</p><p>b</p>) are told apart by a space.
+func blockText(n *html.Node) string {
+	var b strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			b.WriteString(n.Data)
+		case html.ElementNode:
+			if !inlineElements[n.Data] {
+				b.WriteByte(' ')
+			}
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				walk(c)
+			}
+			if !inlineElements[n.Data] {
+				b.WriteByte(' ')
+			}
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// splitList breaks the list into as many consecutive lists as needed for each
+// to fit within max, the numbering of ordered lists carrying on.
+func splitList(list *html.Node, max int, size func(*html.Node) int) {
+	start := 1
+	if x, err := strconv.Atoi(attr(list, "start")); err == nil {
+		start = x
+	}
+	for list != nil {
+		var rest *html.Node
+		var sum, items int
+		for c := list.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode || c.Data != "li" {
+				continue
+			}
+			x := size(c)
+			if sum > 0 && sum+x > max {
+				rest = c
+				break
+			}
+			sum += x
+			items++
+		}
+		if rest == nil {
+			return
+		}
+		next := &html.Node{Type: html.ElementNode, Data: list.Data, DataAtom: list.DataAtom}
+		for _, a := range list.Attr {
+			if a.Key != "start" && a.Key != "id" {
+				next.Attr = append(next.Attr, a)
+			}
+		}
+		start += items
+		if list.Data == "ol" {
+			next.Attr = append(next.Attr, html.Attribute{Key: "start", Val: strconv.Itoa(start)})
+		}
+		list.Parent.InsertBefore(next, list.NextSibling)
+		moveFrom(rest, next)
+		list = next
+	}
+}
+
+// moveFrom moves n and all its following siblings at the end of dest
+func moveFrom(n, dest *html.Node) {
+	for n != nil {
+		next := n.NextSibling
+		n.Parent.RemoveChild(n)
+		dest.AppendChild(n)
+		n = next
+	}
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// mergeTiny moves the sections smaller than MinSectionSize, along with their
+// subsections, into the note of their previous sibling or of their parent
+// (MergeInto). Their headings are kept in the text of that note. Sections with
+// images or tables and those cut by hand with markers are left alone.
+// The cutpatterns into which sections were merged are returned along with the
+// titles of these sections, so that the notes can tell the span they cover.
+// A <merged> element keeps the slot of each section merged so that the
+// Locations, and so the IDs, of the sections after it don't change with
+// MinSectionSize (see Preprocess).
+func mergeTiny(m *meta.Meta, doc *goquery.Document) (MergedRegister map[*html.Node][]string) {
+	MergedRegister = make(map[*html.Node][]string)
+	min := m.Config.MinSectionSize
+	if min <= 0 {
+		return
+	}
+	body := doc.Find("body").Nodes[0]
+	var total int
+	for h := body.FirstChild; h != nil; {
+		if !isHeading(h) {
+			h = h.NextSibling
+			continue
+		}
+		end := sectionEnd(h)
+		target := mergeTarget(h, m.Config.MergeInto)
+		if target == nil || !isTiny(m, h, end) {
+			h = h.NextSibling
+			continue
+		}
+		titles := MergedRegister[target]
+		for n := h; n != end; {
+			next := n.NextSibling
+			body.RemoveChild(n)
+			if isHeading(n) {
+				titles = append(titles, Text(n))
+				target.AppendChild(n)
+			} else if n.Type == html.ElementNode && n.Data == "cutpattern" {
+				titles = append(titles, MergedRegister[n]...)
+				delete(MergedRegister, n)
+				if n.FirstChild != nil {
+					moveFrom(n.FirstChild, target)
+				}
+			}
+			n = next
+		}
+		slot := &html.Node{
+			Type: html.ElementNode,
+			Data: "merged",
+			Attr: []html.Attribute{{Key: "level", Val: strconv.Itoa(headingLevel(h))}},
+		}
+		body.InsertBefore(slot, end)
+		MergedRegister[target] = titles
+		total++
+		h = end
+	}
+	if total != 0 {
+		m.Log.Info().Int("sections", total).Msg("Tiny sections merged")
+	}
+	return
+}
+
+// spanTitles appends the titles of the sections merged into a note to its ID and
+// to the last heading of its RealTitle, e.g. "Bones: Ulna + Radius"
+func spanTitles(ID, title string, merged []string) (string, string) {
+	span := " + " + strings.Join(merged, " + ")
+	const tag = "<span class=heading>"
+	if i := strings.LastIndex(title, tag); i >= 0 {
+		if j := strings.Index(title[i+len(tag):], "</span>"); j >= 0 {
+			at := i + len(tag) + j
+			title = title[:at] + span + title[at:]
+		}
+	}
+	return ID + span, title
+}
+
+func headingLevel(n *html.Node) int {
+	x, _ := strconv.Atoi(n.Data[1:])
+	return x
+}
+
+// sectionEnd returns the node following the last one of the section of h and
+// its subsections, i.e. the next heading of the same or of a higher level
+func sectionEnd(h *html.Node) *html.Node {
+	for n := h.NextSibling; n != nil; n = n.NextSibling {
+		if isHeading(n) && headingLevel(n) <= headingLevel(h) {
+			return n
+		}
+	}
+	return nil
+}
+
+func isTiny(m *meta.Meta, h, end *html.Node) bool {
+	var size int
+	for n := h.NextSibling; n != end; n = n.NextSibling {
+		if n.Type != html.ElementNode || n.Data != "cutpattern" {
+			continue
+		}
+		// several cutpatterns under a same heading are the doing of markers
+		if n.PrevSibling == nil || !isHeading(n.PrevSibling) {
+			return false
+		}
+		s := goquery.Selection{Nodes: []*html.Node{n}}
+		if s.Find("img, table, svg, video, audio").Length() != 0 {
+			return false
+		}
+		size += sizeOf(m, n)
+	}
+	return size < m.Config.MinSectionSize
+}
+
+// mergeTarget returns the cutpattern in which the section of h is to be merged:
+// the last one of its previous sibling, if that one has no subsection, or else
+// the last one of its parent.
+func mergeTarget(h *html.Node, into string) *html.Node {
+	level := headingLevel(h)
+	var parent *html.Node
+	sibling := into == "previous"
+	for n := h.PrevSibling; n != nil && parent == nil; n = n.PrevSibling {
+		if !isHeading(n) {
+			continue
+		}
+		switch x := headingLevel(n); {
+		case x < level:
+			parent = n
+		case x == level && sibling:
+			return lastCutpattern(n)
+		default:
+			// the previous sibling has subsections: its note isn't the one before
+			sibling = false
+		}
+	}
+	if parent == nil {
+		return nil
+	}
+	return lastCutpattern(parent)
+}
+
+// lastCutpattern returns the last cutpattern directly under the heading h
+func lastCutpattern(h *html.Node) (cut *html.Node) {
+	for n := h.NextSibling; n != nil && !isHeading(n); n = n.NextSibling {
+		if n.Type == html.ElementNode && n.Data == "cutpattern" {
+			cut = n
+		}
+	}
+	return
+}
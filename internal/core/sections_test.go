@@ -0,0 +1,314 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// splitDoc splits s into sections the way process does
+func splitDoc(t *testing.T, s string) *goquery.Document {
+	t.Helper()
+	doc := Split(bodyOf(t, s).Nodes[0])
+	normalizeHeadings(doc)
+	return doc
+}
+
+// outline describes the body of a split document: "h2 Title" for headings,
+// the text of the cutpatterns in brackets, "merged h2" for merged slots
+func outline(doc *goquery.Document) (lines []string) {
+	for _, n := range doc.Find("body").Children().Nodes {
+		switch {
+		case isHeading(n):
+			lines = append(lines, n.Data+" "+Text(n))
+		case n.Data == "cutpattern":
+			lines = append(lines, "["+blockText(n)+"]")
+		default:
+			lines = append(lines, n.Data+" h"+attr(n, "level"))
+		}
+	}
+	return
+}
+
+func TestSplitOversized(t *testing.T) {
+	tests := []struct {
+		name, input	string
+		max		int
+		unit		string
+		want		[]string
+		wantHTML	[]string
+	}{
+		{
+			name: "disabled",
+			input: `<h1>A</h1><p>one two three</p><p>four five</p>`,
+			want: []string{"[]", "h1 A", "[one two three four five]"},
+		},
+		{
+			name: "short enough",
+			input: `<h1>A</h1><p>one two three</p><p>four five</p>`,
+			max: 5,
+			want: []string{"[]", "h1 A", "[one two three four five]"},
+		},
+		{
+			name: "between paragraphs",
+			input: `<h1>A</h1><p>a b c</p><p>d e</p><p>f g h i j</p><p>k</p>`,
+			max: 4,
+			want: []string{"[]", "h1 A", "[a b c]", "[d e]", "[f g h i j]", "[k]"},
+		},
+		{
+			name: "words of consecutive paragraphs aren't joined",
+			input: `<h1>A</h1><p>a b c</p><p>d e</p>`,
+			max: 4,
+			want: []string{"[]", "h1 A", "[a b c]", "[d e]"},
+		},
+		{
+			name: "in characters",
+			input: `<h1>A</h1><p>abcd</p><p>ef</p><p>gh</p>`,
+			max: 4,
+			unit: "characters",
+			want: []string{"[]", "h1 A", "[abcd]", "[ef gh]"},
+		},
+		{
+			name: "ordered list carries on its numbering",
+			input: `<h1>A</h1><ol><li>a b</li><li>c d</li><li>e f</li><li>g h</li><li>i j</li></ol>`,
+			max: 4,
+			want: []string{"[]", "h1 A", "[a b c d]", "[e f g h]", "[i j]"},
+			wantHTML: []string{`<ol><li>a b</li>`, `<ol start="3"><li>e f</li>`, `<ol start="5"><li>i j</li>`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testMeta()
+			m.Config.MaxSectionSize = tt.max
+			if tt.unit != "" {
+				m.Config.SectionSizeUnit = tt.unit
+			}
+			doc := splitDoc(t, tt.input)
+			splitOversized(m, doc)
+			if got := outline(doc); strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			h, _ := doc.Html()
+			for _, s := range tt.wantHTML {
+				if !strings.Contains(h, s) {
+					t.Errorf("%q not found in\n%s", s, h)
+				}
+			}
+		})
+	}
+}
+
+func TestCutAtMarkers(t *testing.T) {
+	tests := []struct {
+		name, input, selector	string
+		want			[]string
+	}{
+		{
+			name: "comment",
+			input: `<h1>A</h1><p>a</p><!-- irgen:cut --><p>b</p>`,
+			want: []string{"[]", "h1 A", "[a]", "[b]"},
+		},
+		{
+			name: "no selector by default",
+			input: `<h1>A</h1><p>a</p><hr><p>b</p><!-- irgen:cut --><p>c</p>`,
+			want: []string{"[]", "h1 A", "[a b]", "[c]"},
+		},
+		{
+			name: "hr",
+			input: `<h1>A</h1><p>a</p><hr><p>b</p><hr><p>c</p>`,
+			selector: "hr",
+			want: []string{"[]", "h1 A", "[a]", "[b]", "[c]"},
+		},
+		{
+			name: "hr at the start or the end makes no empty note",
+			input: `<h1>A</h1><hr><p>a</p><hr><p>b</p><hr><h1>B</h1><p>c</p>`,
+			selector: "hr",
+			want: []string{"[]", "h1 A", "[a]", "[b]", "h1 B", "[c]"},
+		},
+		{
+			name: "section made of a single hr is kept",
+			input: `<h1>A</h1><hr><h1>B</h1><p>c</p>`,
+			selector: "hr",
+			want: []string{"[]", "h1 A", "[]", "h1 B", "[c]"},
+		},
+		{
+			name: "marker with content starts the next note",
+			input: `<h1>A</h1><p>a</p><p class="cut">b</p><p>c</p>`,
+			selector: "p.cut",
+			want: []string{"[]", "h1 A", "[a]", "[b c]"},
+		},
+		{
+			name: "nested marker splits the elements wrapping it",
+			input: `<h1>A</h1><div class="box"><p>a</p><div><p>b</p><hr><p>c</p></div></div>`,
+			selector: "hr",
+			want: []string{"[]", "h1 A", "[a b]", "[c]"},
+		},
+		{
+			name: "marker in a table cell is ignored",
+			input: `<h1>A</h1><p>a</p><table><tr><td>x<hr>y</td><td>z</td></tr></table><hr><p>b</p>`,
+			selector: "hr",
+			want: []string{"[]", "h1 A", "[a x y z]", "[b]"},
+		},
+		{
+			name: "markers in list items, figures and pre are ignored",
+			input: `<h1>A</h1><ul><li>a<!-- irgen:cut -->b</li></ul><figure><img src="x.png"><hr><figcaption>c</figcaption></figure><pre>d<!-- irgen:cut -->e</pre>`,
+			selector: "hr",
+			want: []string{"[]", "h1 A", "[ab c de]"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testMeta()
+			m.Config.CutSelector = tt.selector
+			doc := splitDoc(t, tt.input)
+			cutAtMarkers(m, doc)
+			if got := outline(doc); strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if tables := doc.Find("table").Length(); tables > 1 {
+				t.Errorf("table split in %d", tables)
+			}
+		})
+	}
+}
+
+func TestCutAtMarkersKeepsWrappers(t *testing.T) {
+	m := testMeta()
+	m.Config.CutSelector = "hr"
+	doc := splitDoc(t, `<h1>A</h1><div class="box" id="x"><p>a</p><hr><p>b</p></div>`)
+	cutAtMarkers(m, doc)
+	var got []string
+	doc.Find("body > cutpattern").Each(func(i int, s *goquery.Selection) {
+		h, _ := s.Html()
+		got = append(got, h)
+	})
+	want := []string{``, `<div class="box" id="x"><p>a</p></div>`, `<div class="box"><p>b</p></div>`}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestMergeTiny(t *testing.T) {
+	const bones = `<h1>Bones</h1><p>bones are hard here</p><h2>Ulna</h2><p>long bone of arm</p>`
+	tests := []struct {
+		name, input, into	string
+		min			int
+		want			[]string // Location and text of the notes
+		wantMerged		[]string
+	}{
+		{
+			name: "disabled",
+			input: bones + `<h2>Tiny</h2><p>x</p>`,
+			want: []string{"1 §1 [bones are hard here]", "1.1 §1 [long bone of arm]", "1.2 §1 [x]"},
+		},
+		{
+			name: "into the previous sibling, the next sections keeping their numbers",
+			input: bones + `<h2>Tiny</h2><p>x</p><h2>Hand</h2><p>many small bones here</p>`,
+			min: 3,
+			want: []string{"1 §1 [bones are hard here]", "1.1 §1 [long bone of arm Tiny x]", "1.3 §1 [many small bones here]"},
+			wantMerged: []string{"Tiny"},
+		},
+		{
+			name: "into the parent",
+			input: bones + `<h2>Tiny</h2><p>x</p><h2>Hand</h2><p>many small bones here</p>`,
+			into: "parent",
+			min: 3,
+			want: []string{"1 §1 [bones are hard here Tiny x]", "1.1 §1 [long bone of arm]", "1.3 §1 [many small bones here]"},
+			wantMerged: []string{"Tiny"},
+		},
+		{
+			name: "along with the subsections",
+			input: bones + `<h2>Small</h2><p>a</p><h3>Sub</h3><p>b</p><h2>Hand</h2><p>many small bones here</p>`,
+			min: 3,
+			want: []string{"1 §1 [bones are hard here]", "1.1 §1 [long bone of arm Small a Sub b]", "1.3 §1 [many small bones here]"},
+			wantMerged: []string{"Small", "Sub"},
+		},
+		{
+			name: "words of consecutive paragraphs are counted apart",
+			input: bones + `<h2>Short</h2><p>a b</p><p>c</p>`,
+			min: 3,
+			want: []string{"1 §1 [bones are hard here]", "1.1 §1 [long bone of arm]", "1.2 §1 [a b c]"},
+		},
+		{
+			name: "sections with images are left alone",
+			input: bones + `<h2>Pic</h2><p><img src="a.png"></p>`,
+			min: 3,
+			want: []string{"1 §1 [bones are hard here]", "1.1 §1 [long bone of arm]", "1.2 §1 []"},
+		},
+		{
+			name: "sections cut by markers are left alone",
+			input: bones + `<h2>Cut</h2><p>a</p><!-- irgen:cut --><p>b</p>`,
+			min: 3,
+			want: []string{"1 §1 [bones are hard here]", "1.1 §1 [long bone of arm]", "1.2 §1 [a]", "1.2 §2 [b]"},
+		},
+		{
+			name: "first section has nothing to be merged into",
+			input: `<h1>A</h1><p>x</p><h1>B</h1><p>long enough text here</p>`,
+			min: 3,
+			want: []string{"1 §1 [x]", "2 §1 [long enough text here]"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testMeta()
+			m.Config.MinSectionSize = tt.min
+			if tt.into != "" {
+				m.Config.MergeInto = tt.into
+			}
+			doc := splitDoc(t, tt.input)
+			cutAtMarkers(m, doc)
+			merged := mergeTiny(m, doc)
+			locs, _ := Preprocess(m, doc)
+			var got, gotMerged []string
+			for _, n := range doc.Find("body > cutpattern").Nodes {
+				if loc := locs[n]; len(loc) > 1 {
+					got = append(got, loc.miniStr()+" ["+blockText(n)+"]")
+				}
+				gotMerged = append(gotMerged, merged[n]...)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if strings.Join(gotMerged, "|") != strings.Join(tt.wantMerged, "|") {
+				t.Errorf("got merged %q, want %q", gotMerged, tt.wantMerged)
+			}
+		})
+	}
+}
+
+func TestNormalizeHeadings(t *testing.T) {
+	tests := []struct {
+		name, input	string
+		want		[]string
+	}{
+		{
+			name: "skipped levels collapse",
+			input: `<h2>A</h2><h5>B</h5><h6>C</h6><h3>D</h3><h2>E</h2>`,
+			want: []string{"h1 A", "h2 B", "h3 C", "h2 D", "h1 E"},
+		},
+		{
+			name: "shifted by the extractor",
+			input: `<h0>A</h0><h1>B</h1><h1>C</h1>`,
+			want: []string{"h1 A", "h2 B", "h2 C"},
+		},
+		{
+			name: "deeper than h6",
+			input: `<h1>A</h1><h2>B</h2><h3>C</h3><h4>D</h4><h5>E</h5><h6>F</h6><h7>G</h7><h8>H</h8>`,
+			want: []string{"h1 A", "h2 B", "h3 C", "h4 D", "h5 E", "h6 F", "h7 G", "h8 H"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, line := range outline(splitDoc(t, tt.input)) {
+				if strings.HasPrefix(line, "h") {
+					got = append(got, line)
+				}
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,339 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"github.com/tassa-yoniso-manasi-karoto/irgen/internal/meta"
+	"github.com/tassa-yoniso-manasi-karoto/irgen/internal/common"
+)
+
+/*
+A collection is a Wikipedia category, along with its subcategories down to a
+given depth, or a "List of ..." page. Each of its articles is imported as a job
+of its own into a subdeck of the deck of the collection.
+
+The articles done are written down in a journal next to the output files so that
+a run that got interrupted can be resumed without adding their notes twice.
+*/
+
+const nsCategory = 14
+
+var wikiAPIURL = "https://%s.wikipedia.org/w/api.php"
+
+// ExecuteWikiCollection imports every article of the category or list page at URL.
+// Subcategories are explored down to depth levels, 0 being the category alone.
+func ExecuteWikiCollection(ctx context.Context, m *meta.Meta, URL string, depth int) (success bool) {
+	x, article, ok := matchExtractor(URL)
+	if !ok || x.Name != wiki.Name {
+		m.Log.Error().Str("url", URL).Msg("collections can only be imported from Wikipedia")
+		return
+	}
+	title, ns, err := wikiResolveTitle(m, article.Lang, article.Name)
+	if err != nil {
+		m.Log.Error().Err(err).Str("page", article.Name).Msg("couldn't query the MediaWiki API")
+		return
+	}
+	var titles []string
+	if ns == nsCategory {
+		titles, err = wikiCategoryMembers(m, article.Lang, title, depth, make(map[string]bool))
+	} else {
+		titles, err = wikiListMembers(m, article.Lang, title)
+	}
+	if err != nil {
+		m.Log.Error().Err(err).Str("page", title).Msg("couldn't list the articles of the collection")
+		return
+	}
+	name := title
+	if ns == nsCategory {
+		_, name, _ = strings.Cut(title, ":")
+	}
+	deck := fmt.Sprint(wiki.Name, " - ", name)
+	if m.Config.Deck != "" {
+		deck = m.Config.Deck
+	}
+	journal := filepath.Join(m.Config.DestDir, safeFilename(deck) + ".journal")
+	done, err := readJournal(journal)
+	if err != nil {
+		m.Log.Error().Err(err).Str("journal", journal).Msg("couldn't read the journal of the collection")
+		return
+	}
+	inputs, total := collectionInputs(m, article.Lang, deck, titles, done)
+	m.Log.Info().
+		Int("articles", total).
+		Int("already done", total-len(inputs)).
+		Str("journal", journal).
+		Msg("Collection " + title)
+	if len(inputs) == 0 {
+		m.Log.Info().Msg("nothing left to import, remove the journal to import the collection again")
+		return true
+	}
+	// nothing gets imported, so nothing is done either
+	if m.Config.DryRun || m.Config.Outline {
+		return executeAll(ctx, m, inputs, nil) == 0
+	}
+	f, err := os.OpenFile(journal, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		m.Log.Error().Err(err).Str("journal", journal).Msg("couldn't open the journal of the collection for writing")
+		return
+	}
+	defer f.Close()
+	failed := executeAll(ctx, m, inputs, func(input InputType) {
+		if _, err := fmt.Fprintln(f, input.Targ); err != nil {
+			m.Log.Error().Err(err).Str("journal", journal).Msg("couldn't write to the journal")
+		}
+	})
+	return failed == 0
+}
+
+// collectionInputs makes a job of each article of the collection that isn't done yet,
+// total being the number of distinct articles.
+func collectionInputs(m *meta.Meta, lang, deck string, titles []string, done map[string]bool) (inputs []InputType, total int) {
+	seen := make(map[string]bool)
+	for _, t := range titles {
+		targ := wikiArticleURL(lang, t)
+		if seen[targ] {
+			continue
+		}
+		seen[targ] = true
+		if done[targ] {
+			m.Log.Debug().Str("article", t).Msg("already imported according to the journal")
+			continue
+		}
+		inputs = append(inputs, InputType{Targ: targ, Deck: deck + "::" + t})
+	}
+	return inputs, len(seen)
+}
+
+// the journal holds the URL of an article done per line
+func readJournal(path string) (done map[string]bool, err error) {
+	done = make(map[string]bool)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return done, nil
+	} else if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			done[line] = true
+		}
+	}
+	return done, scanner.Err()
+}
+
+func wikiArticleURL(lang, title string) string {
+	return "https://" + lang + ".wikipedia.org/wiki/" + url.PathEscape(strings.ReplaceAll(title, " ", "_"))
+}
+
+// wikiResolveTitle follows the redirects of title and returns its namespace.
+func wikiResolveTitle(m *meta.Meta, lang, title string) (resolved string, ns int, err error) {
+	params := url.Values{"titles": {title}, "redirects": {"1"}}
+	err = wikiQuery(m, lang, params, func(query json.RawMessage) error {
+		var result struct {
+			Pages []struct {
+				Title	string	`json:"title"`
+				NS	int	`json:"ns"`
+				Missing	bool	`json:"missing"`
+			} `json:"pages"`
+		}
+		if err := json.Unmarshal(query, &result); err != nil {
+			return err
+		}
+		if len(result.Pages) == 0 || result.Pages[0].Missing {
+			return fmt.Errorf("page %q doesn't exist", title)
+		}
+		resolved, ns = result.Pages[0].Title, result.Pages[0].NS
+		return nil
+	})
+	return
+}
+
+func wikiCategoryMembers(m *meta.Meta, lang, category string, depth int, visited map[string]bool) (titles []string, err error) {
+	visited[category] = true
+	var subcats []string
+	params := url.Values{
+		"list": {"categorymembers"},
+		"cmtitle": {category},
+		"cmtype": {"page|subcat"},
+		"cmlimit": {"max"},
+	}
+	err = wikiQuery(m, lang, params, func(query json.RawMessage) error {
+		var result struct {
+			Members []struct {
+				Title	string	`json:"title"`
+				NS	int	`json:"ns"`
+			} `json:"categorymembers"`
+		}
+		if err := json.Unmarshal(query, &result); err != nil {
+			return err
+		}
+		for _, member := range result.Members {
+			switch member.NS {
+			case 0:
+				titles = append(titles, member.Title)
+			case nsCategory:
+				subcats = append(subcats, member.Title)
+			}
+		}
+		return nil
+	})
+	if err != nil || depth <= 0 {
+		return
+	}
+	for _, subcat := range subcats {
+		// categories of Wikipedia aren't a tree, they can loop
+		if visited[subcat] {
+			continue
+		}
+		m.Log.Debug().Str("subcategory", subcat).Int("depth left", depth-1).Msg("exploring")
+		sub, err := wikiCategoryMembers(m, lang, subcat, depth-1, visited)
+		if err != nil {
+			return titles, err
+		}
+		titles = append(titles, sub...)
+	}
+	return
+}
+
+// wikiListMembers returns the articles linked from the list page, in the order
+// in which they appear, leaving out those only found in navboxes, references...
+func wikiListMembers(m *meta.Meta, lang, title string) (titles []string, err error) {
+	// the API only gives links in alphabetical order, without their position in the page
+	articles := make(map[string]bool)
+	params := url.Values{
+		"titles": {title},
+		"prop": {"links"},
+		"plnamespace": {"0"},
+		"pllimit": {"max"},
+	}
+	err = wikiQuery(m, lang, params, func(query json.RawMessage) error {
+		var result struct {
+			Pages []struct {
+				Links []struct {
+					Title string `json:"title"`
+				} `json:"links"`
+			} `json:"pages"`
+		}
+		if err := json.Unmarshal(query, &result); err != nil {
+			return err
+		}
+		for _, page := range result.Pages {
+			for _, link := range page.Links {
+				articles[link.Title] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	var parsed struct {
+		Parse struct {
+			Text string `json:"text"`
+		} `json:"parse"`
+	}
+	params = url.Values{"action": {"parse"}, "page": {title}, "prop": {"text"}}
+	if err = wikiAPI(m, lang, params, &parsed); err != nil {
+		return
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(parsed.Parse.Text))
+	if err != nil {
+		return
+	}
+	doc.Find(".navbox, .navbox-inner, .sistersitebox, .hatnote, .reflist, .references, .metadata, table.sidebar").Remove()
+	seen := make(map[string]bool)
+	doc.Find("a[href^='/wiki/']").Each(func(i int, s *goquery.Selection) {
+		href, _ := s.Attr("href")
+		t, err := url.PathUnescape(strings.TrimPrefix(href, "/wiki/"))
+		if err != nil {
+			return
+		}
+		t, _, _ = strings.Cut(strings.ReplaceAll(t, "_", " "), "#")
+		if !articles[t] || seen[t] || t == title {
+			return
+		}
+		seen[t] = true
+		titles = append(titles, t)
+	})
+	if len(titles) == 0 {
+		err = fmt.Errorf("no article linked from %q", title)
+	}
+	return
+}
+
+// wikiQuery runs a query of the MediaWiki API, handing over the "query" object
+// of each batch of results to handle until there are no more.
+func wikiQuery(m *meta.Meta, lang string, params url.Values, handle func(json.RawMessage) error) error {
+	params.Set("action", "query")
+	for {
+		var resp struct {
+			Continue	map[string]string	`json:"continue"`
+			Query		json.RawMessage		`json:"query"`
+		}
+		if err := wikiAPI(m, lang, params, &resp); err != nil {
+			return err
+		}
+		if len(resp.Query) != 0 {
+			if err := handle(resp.Query); err != nil {
+				return err
+			}
+		}
+		if len(resp.Continue) == 0 {
+			return nil
+		}
+		for key, value := range resp.Continue {
+			params.Set(key, value)
+		}
+	}
+}
+
+func wikiAPI(m *meta.Meta, lang string, params url.Values, v any) error {
+	params.Set("format", "json")
+	params.Set("formatversion", "2")
+	URL := fmt.Sprintf(wikiAPIURL, lang) + "?" + params.Encode()
+	req, err := http.NewRequest(http.MethodGet, URL, nil)
+	if err != nil {
+		return err
+	}
+	// required by the policy of Wikimedia
+	req.Header.Set("User-Agent", "irgen/" + common.Version + " (https://github.com/tassa-yoniso-manasi-karoto/irgen)")
+	m.Log.Trace().Str("url", URL).Msg("MediaWiki API request")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("MediaWiki API responded %s", resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("reading MediaWiki API response failed: %w", err)
+	}
+	var apiErr struct {
+		Error *struct {
+			Code	string	`json:"code"`
+			Info	string	`json:"info"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &apiErr); err != nil {
+		return fmt.Errorf("malformed MediaWiki API response: %w", err)
+	}
+	if apiErr.Error != nil {
+		return fmt.Errorf("MediaWiki API error %s: %s", apiErr.Error.Code, apiErr.Error.Info)
+	}
+	return json.Unmarshal(body, v)
+}
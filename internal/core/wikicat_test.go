@@ -0,0 +1,153 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// wikiServer serves the MediaWiki API of the categories, each given as the
+// batches of its members, a batch pointing to the next with cmcontinue
+func wikiServer(t *testing.T, categories map[string][][]string) (requests *[]string) {
+	t.Helper()
+	requests = new([]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		*requests = append(*requests, q.Get("cmtitle")+" "+q.Get("cmcontinue"))
+		if q.Get("format") != "json" || q.Get("formatversion") != "2" || r.URL.Path != "/en/api.php" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		if q.Get("titles") != "" {
+			fmt.Fprintf(w, `{"query": {"pages": [{"title": %q, "ns": 14}]}}`, q.Get("titles"))
+			return
+		}
+		batches, ok := categories[q.Get("cmtitle")]
+		if !ok {
+			fmt.Fprint(w, `{"error": {"code": "invalidcategory", "info": "no such category"}}`)
+			return
+		}
+		i := 0
+		if c := q.Get("cmcontinue"); c != "" {
+			fmt.Sscanf(c, "page|%d", &i)
+		}
+		var members []map[string]any
+		for _, title := range batches[i] {
+			ns := 0
+			if strings.HasPrefix(title, "Category:") {
+				ns = nsCategory
+			}
+			members = append(members, map[string]any{"title": title, "ns": ns})
+		}
+		resp := map[string]any{"batchcomplete": true, "query": map[string]any{"categorymembers": members}}
+		if i+1 < len(batches) {
+			resp["continue"] = map[string]string{"cmcontinue": fmt.Sprintf("page|%d", i+1), "continue": "-||"}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(srv.Close)
+	old := wikiAPIURL
+	wikiAPIURL = srv.URL + "/%s/api.php"
+	t.Cleanup(func() { wikiAPIURL = old })
+	return
+}
+
+func TestWikiCategoryMembers(t *testing.T) {
+	categories := map[string][][]string{
+		"Category:Bones": {
+			{"Ulna", "Category:Arm bones"},
+			{"Radius", "Category:Skeleton"},
+		},
+		"Category:Arm bones": {{"Humerus", "Ulna", "Category:Bones", "Category:Hand bones"}},
+		"Category:Skeleton": {{"Skull"}},
+		"Category:Hand bones": {{"Scaphoid"}},
+	}
+	tests := []struct {
+		depth		int
+		want		string
+		wantRequests	string
+	}{
+		{
+			depth: 0,
+			want: "[Ulna Radius]",
+			wantRequests: "[Category:Bones  Category:Bones page|1]",
+		},
+		{
+			depth: 1,
+			want: "[Ulna Radius Humerus Ulna Skull]",
+			wantRequests: "[Category:Bones  Category:Bones page|1 Category:Arm bones  Category:Skeleton ]",
+		},
+		{
+			depth: 2,
+			want: "[Ulna Radius Humerus Ulna Scaphoid Skull]",
+			wantRequests: "[Category:Bones  Category:Bones page|1 Category:Arm bones  Category:Hand bones  Category:Skeleton ]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint("depth ", tt.depth), func(t *testing.T) {
+			requests := wikiServer(t, categories)
+			titles, err := wikiCategoryMembers(testMeta(), "en", "Category:Bones", tt.depth, make(map[string]bool))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fmt.Sprint(titles); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+			if got := fmt.Sprint(*requests); got != tt.wantRequests {
+				t.Errorf("got requests %s, want %s", got, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestWikiCategoryMembersError(t *testing.T) {
+	wikiServer(t, map[string][][]string{"Category:Bones": {{"Ulna", "Category:Missing"}}})
+	titles, err := wikiCategoryMembers(testMeta(), "en", "Category:Bones", 1, make(map[string]bool))
+	if err == nil || !strings.Contains(err.Error(), "invalidcategory") {
+		t.Errorf("got %v, want the error of the API", err)
+	}
+	if fmt.Sprint(titles) != "[Ulna]" {
+		t.Errorf("got %v, want the titles found before the error", titles)
+	}
+}
+
+func TestCollectionInputs(t *testing.T) {
+	journal := filepath.Join(t.TempDir(), "Bones.journal")
+	lines := "https://en.wikipedia.org/wiki/Ulna\n\n  https://en.wikipedia.org/wiki/Hand_bone  \n"
+	if err := os.WriteFile(journal, []byte(lines), 0644); err != nil {
+		t.Fatal(err)
+	}
+	done, err := readJournal(journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs, total := collectionInputs(testMeta(), "en", "Bones", []string{"Ulna", "Radius", "Hand bone", "Radius", "Carpal bones"}, done)
+	want := "[{https://en.wikipedia.org/wiki/Radius Bones::Radius []} {https://en.wikipedia.org/wiki/Carpal_bones Bones::Carpal bones []}]"
+	if got := fmt.Sprint(inputs); got != want || total != 4 {
+		t.Errorf("got %s of %d, want %s of 4", got, total, want)
+	}
+	// a collection never imported has no journal yet
+	if done, err := readJournal(filepath.Join(t.TempDir(), "none.journal")); err != nil || len(done) != 0 {
+		t.Errorf("got %v, %v, want nothing done", done, err)
+	}
+}
+
+func TestExecuteWikiCollectionAllDone(t *testing.T) {
+	wikiServer(t, map[string][][]string{"Category:Bones": {{"Ulna"}, {"Radius"}}})
+	m := testMeta()
+	m.Config.DestDir = t.TempDir()
+	journal := filepath.Join(m.Config.DestDir, safeFilename("Wikipedia - Bones")+".journal")
+	lines := "https://en.wikipedia.org/wiki/Radius\nhttps://en.wikipedia.org/wiki/Ulna\n"
+	if err := os.WriteFile(journal, []byte(lines), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// nothing is fetched since every article is done
+	if !ExecuteWikiCollection(context.Background(), m, "https://en.wikipedia.org/wiki/Category:Bones", 0) {
+		t.Error("expected a success")
+	}
+}
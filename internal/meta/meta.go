@@ -1,8 +1,10 @@
 package meta
 
 import (
-	//"encoding/json"
+	stdjson "encoding/json"
 	"fmt"
+	"maps"
+	"slices"
 	"time"
 	"os"
 	"strings"
@@ -14,11 +16,92 @@ import (
 	"github.com/rs/zerolog"
 )
 
+type SectionFilter struct {
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+}
+
+// Pin pins the images matched by Selector, or whose file is File, to the sections whose
+// heading path matches Under, or by default to the section they are in.
+type Pin struct {
+	Under string `json:"under,omitempty"`
+	Selector string `json:"selector,omitempty"`
+	File string `json:"file,omitempty"`
+}
+
+// Function is a capillary to run, with its scope and options. The options left unset
+// take the defaults of the capillary.
+type Function struct {
+	Name string `json:"name"`
+	// where to look, by the number of levels to climb from the heading of the note: 1 is
+	// the section of its parent heading, along with its subsections, and 0, like any
+	// scope past the top level, the whole document. FromSuperior is the exception, it
+	// counts the heading of the note as 1: FromSuperior=2 looks where
+	// FromSuperiorAndDescendants=1 does.
+	Scope int `json:"scope"`
+	// kinds of elements to gather: img, table, svg, video, math, pre, blockquote or any CSS selector
+	Collect []string `json:"collect"`
+	// CSS selectors of the containers to take along with the elements gathered, e.g. figure
+	Containers []string `json:"containers"`
+	// limits to the objects gathered for a note, 0 meaning none
+	MaxObjects int `json:"maxObjects"`
+	MaxBytes int `json:"maxBytes"`
+	// whether to keep the captions of the objects
+	Captions *bool `json:"captions"`
+}
+
 type Config struct {
 	CollectionMedia string `json:"collectionMedia"`
 	DestDir string `json:"destDir"`
-	Functions []string
-	FunctionsScopes []int
+	Charset string `json:"charset"`
+	ChapterDecks bool `json:"chapterDecks"`
+	Deck string `json:"deck"`
+	Tags []string `json:"tags"`
+	// what to extract from the pages of websites that have no extractor
+	ContentSelector string `json:"contentSelector"`
+	// rules to find the headings of documents that have no heading tags: aria, class, fontsize, bold
+	HeadingInference []string `json:"headingInference"`
+	// pattern of the classes of paragraphs that are headings, its first number being the level (1 if none)
+	HeadingClasses string `json:"headingClasses"`
+	// only show the headings of the documents instead of making notes
+	PreviewHeadings bool `json:"previewHeadings"`
+	// print the outline of the notes of the documents instead of importing them
+	Outline bool `json:"outline"`
+	// run the whole pipeline but only report what the import would do, writing nothing
+	DryRun bool `json:"dryRun"`
+	// rewrite the RealTitle and Context of the notes already in the deck instead of skipping them
+	Update bool `json:"update"`
+	// sections longer than that are cut into several notes, 0 for no limit
+	MaxSectionSize int `json:"maxSectionSize"`
+	// unit of MaxSectionSize: words or characters
+	SectionSizeUnit string `json:"sectionSizeUnit"`
+	// sections smaller than that are merged into another note, 0 to never merge
+	MinSectionSize int `json:"minSectionSize"`
+	// note in which tiny sections are merged: that of their previous sibling or of their parent
+	MergeInto string `json:"mergeInto"`
+	// CSS selector of the elements at which sections are cut into several notes, e.g. "hr"
+	CutSelector string `json:"cutSelector"`
+	// images shown in the context of every note of a subtree
+	Pins []Pin `json:"pins"`
+	// list the images of the documents to pick those to pin instead of importing them
+	PinPreview bool `json:"pinPreview"`
+	// don't make a note of the lead section, before the first heading
+	SkipOverview bool `json:"skipOverview"`
+	// patterns of the paths of headings of the sections to import / to leave out, e.g. "Anatomy/**"
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+	// the same, specific to an extractor, by its name (e.g. "Wikipedia", "local")
+	Filters map[string]SectionFilter `json:"filters"`
+	// images and tables to move from the Text of the notes to their Context: trailing, all or none if empty
+	MoveToContext string `json:"moveToContext"`
+	// labels of the references to figures and tables looked for by UsingRef, e.g. "Fig." in "Fig. 3.2"
+	FigureLabels []string `json:"figureLabels"`
+	TableLabels []string `json:"tableLabels"`
+	// regexes of the paragraphs next to images and tables that are their captions, besides
+	// those starting with a reference to a figure or table
+	CaptionPatterns []string `json:"captionPatterns"`
+	// decoded by hand from either the shorthand string or an array of objects
+	Functions []Function `json:"functions" koanf:"-"`
 	MaxTitles int `json:"maxTitles"`
 	ResXMax   int `json:"resXMax"`
 	ResYMax   int `json:"resYMax"`
@@ -40,8 +123,18 @@ func New() *Meta {
 		Log:   ConsoleWriter,
 		Koanf: koanf.New("."),
 		Config: Config{
-			Functions: []string{"FromSuperior", "FromSuperior", "FromSuperiorAndDescendants", "FromSuperiorAndDescendants"},
-			FunctionsScopes: []int{1, 2, 3, 10},
+			Functions: []Function{
+				{Name: "FromSuperior", Scope: 1},
+				{Name: "FromSuperior", Scope: 2},
+				{Name: "FromSuperiorAndDescendants", Scope: 3},
+				{Name: "FromSuperiorAndDescendants", Scope: 10},
+			},
+			HeadingInference: []string{"aria", "class", "fontsize", "bold"},
+			FigureLabels: []string{"Fig.", "Figure", "Abb.", "Abbildung"},
+			TableLabels: []string{"Table", "Tab.", "Tabelle", "Tableau"},
+			HeadingClasses: `(?i)^(?:heading|titre|title|überschrift|h)[-_ ]?([1-6])$`,
+			SectionSizeUnit: "words",
+			MergeInto: "previous",
 			MaxTitles: 3,
 			ResXMax:   1920,
 			ResYMax:   1080,
@@ -49,6 +142,35 @@ func New() *Meta {
 	}
 }
 
+// Clone returns a copy of c that shares none of its slices and maps, for a job to alter
+// its config without affecting the others.
+func (c Config) Clone() Config {
+	c.Tags = slices.Clone(c.Tags)
+	c.HeadingInference = slices.Clone(c.HeadingInference)
+	c.Pins = slices.Clone(c.Pins)
+	c.Include = slices.Clone(c.Include)
+	c.Exclude = slices.Clone(c.Exclude)
+	c.Filters = maps.Clone(c.Filters)
+	for name, filter := range c.Filters {
+		filter.Include = slices.Clone(filter.Include)
+		filter.Exclude = slices.Clone(filter.Exclude)
+		c.Filters[name] = filter
+	}
+	c.FigureLabels = slices.Clone(c.FigureLabels)
+	c.TableLabels = slices.Clone(c.TableLabels)
+	c.CaptionPatterns = slices.Clone(c.CaptionPatterns)
+	c.Functions = slices.Clone(c.Functions)
+	for i, fn := range c.Functions {
+		c.Functions[i].Collect = slices.Clone(fn.Collect)
+		c.Functions[i].Containers = slices.Clone(fn.Containers)
+		if fn.Captions != nil {
+			captions := *fn.Captions
+			c.Functions[i].Captions = &captions
+		}
+	}
+	return c
+}
+
 func (m *Meta) LoadConfig() error {
 	zerolog.SetGlobalLevel(zerolog.InfoLevel)
 	if false && strings.Contains(os.Args[0], "-dev-") {		
@@ -64,20 +186,15 @@ func (m *Meta) LoadConfig() error {
 	}
 	err := m.Koanf.Unmarshal("", &m.Config)
 	// clear defaults if config.json exist
-	m.Config.Functions = []string{}
-	m.Config.FunctionsScopes = []int{}
+	m.Config.Functions = []Function{}
 	
-	for _, field := range strings.Fields(m.Koanf.String("functions")) {
-		parts := strings.Split(field, "=")
-		if len(parts) != 2 {
-			continue
-		}
-		scope, err := strconv.Atoi(parts[1])
-		if err != nil {
-			continue
+	switch m.Koanf.Get("functions").(type) {
+	case string:
+		m.Config.Functions = parseFunctions(m.Koanf.String("functions"))
+	case []interface{}:
+		if e := m.Koanf.Unmarshal("functions", &m.Config.Functions); e != nil {
+			return fmt.Errorf("error loading functions: %v", e)
 		}
-		m.Config.Functions = append(m.Config.Functions, parts[0])
-		m.Config.FunctionsScopes = append(m.Config.FunctionsScopes, scope)
 	}
 	m.LogConfig("config state at LoadConfig()")
 	// TODO rm ↓?
@@ -93,17 +210,85 @@ func (m *Meta) LoadConfig() error {
 
 
 
+// SavePins adds pins to those of config.json, its other keys being kept as they are
+func (m *Meta) SavePins(pins []Pin) error {
+	conf := make(map[string]interface{})
+	data, err := os.ReadFile("config.json")
+	if err == nil {
+		if conf, err = json.Parser().Unmarshal(data); err != nil {
+			return fmt.Errorf("error reading config: %v", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if conf == nil {
+		conf = make(map[string]interface{})
+	}
+	// those of the file rather than m.Config.Pins, which other jobs don't share
+	saved, _ := conf["pins"].([]interface{})
+	for _, pin := range pins {
+		saved = append(saved, pin)
+	}
+	conf["pins"] = saved
+	if data, err = stdjson.MarshalIndent(conf, "", "\t"); err != nil {
+		return err
+	}
+	return os.WriteFile("config.json", append(data, '\n'), 0644)
+}
+
+// parseFunctions parses the shorthand syntax of the functions, e.g.
+// "FromSuperior=1 FromSuperiorAndDescendants=3", ignoring malformed ones
+func parseFunctions(s string) (fns []Function) {
+	for _, field := range strings.Fields(s) {
+		parts := strings.Split(field, "=")
+		if len(parts) != 2 {
+			continue
+		}
+		scope, err := strconv.Atoi(parts[1])
+		if err != nil {
+			continue
+		}
+		fns = append(fns, Function{Name: parts[0], Scope: scope})
+	}
+	return
+}
+
 func (m *Meta) LogConfig(s string)  {
 	msg := "config state:"
 	if s != "" {
 		msg = s
 	}
 	m.Log.Debug().
-		Strs("Functions", m.Config.Functions).
-		Ints("FunctionsScopes", m.Config.FunctionsScopes).
+		Interface("Functions", m.Config.Functions).
 		Int("MaxTitles", m.Config.MaxTitles).
 		Int("ResXMax", m.Config.ResXMax).
 		Int("ResYMax", m.Config.ResYMax).
+		Str("Charset", m.Config.Charset).
+		Bool("ChapterDecks", m.Config.ChapterDecks).
+		Str("Deck", m.Config.Deck).
+		Strs("Tags", m.Config.Tags).
+		Str("ContentSelector", m.Config.ContentSelector).
+		Strs("HeadingInference", m.Config.HeadingInference).
+		Str("HeadingClasses", m.Config.HeadingClasses).
+		Bool("PreviewHeadings", m.Config.PreviewHeadings).
+		Bool("Outline", m.Config.Outline).
+		Bool("DryRun", m.Config.DryRun).
+		Bool("Update", m.Config.Update).
+		Int("MaxSectionSize", m.Config.MaxSectionSize).
+		Str("SectionSizeUnit", m.Config.SectionSizeUnit).
+		Str("CutSelector", m.Config.CutSelector).
+		Int("MinSectionSize", m.Config.MinSectionSize).
+		Str("MergeInto", m.Config.MergeInto).
+		Interface("Pins", m.Config.Pins).
+		Bool("PinPreview", m.Config.PinPreview).
+		Bool("SkipOverview", m.Config.SkipOverview).
+		Strs("Include", m.Config.Include).
+		Strs("Exclude", m.Config.Exclude).
+		Interface("Filters", m.Config.Filters).
+		Str("MoveToContext", m.Config.MoveToContext).
+		Strs("FigureLabels", m.Config.FigureLabels).
+		Strs("TableLabels", m.Config.TableLabels).
+		Strs("CaptionPatterns", m.Config.CaptionPatterns).
 		Msg(msg)
 }
 
@@ -0,0 +1,208 @@
+package meta
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func testMeta() *Meta {
+	m := New()
+	m.Log = zerolog.Nop()
+	return m
+}
+
+// inTempDir runs the rest of the test from a temporary directory holding config, if any,
+// as config.json
+func inTempDir(t *testing.T, config string) {
+	t.Helper()
+	dir := t.TempDir()
+	if config != "" {
+		if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestSavePins(t *testing.T) {
+	pin := Pin{Under: "Anatomy/**", File: "a.png"}
+	tests := []struct {
+		name, config	string
+		want		[]Pin
+		wantDeck	string
+	}{
+		{
+			name: "no config.json",
+			want: []Pin{pin},
+		},
+		{
+			name: "appended to the pins of the file, the other keys kept",
+			config: `{"deck": "Bones", "pins": [{"selector": "img.map"}]}`,
+			want: []Pin{{Selector: "img.map"}, pin},
+			wantDeck: "Bones",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inTempDir(t, tt.config)
+			if err := testMeta().SavePins([]Pin{pin}); err != nil {
+				t.Fatal(err)
+			}
+			m := testMeta()
+			if err := m.LoadConfig(); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(m.Config.Pins, tt.want) {
+				t.Errorf("got pins %+v, want %+v", m.Config.Pins, tt.want)
+			}
+			if m.Config.Deck != tt.wantDeck {
+				t.Errorf("got deck %q, want %q", m.Config.Deck, tt.wantDeck)
+			}
+		})
+	}
+}
+
+func TestSavePinsInvalidConfig(t *testing.T) {
+	inTempDir(t, `{"pins": [`)
+	if err := testMeta().SavePins([]Pin{{File: "a.png"}}); err == nil {
+		t.Error("expected an error")
+	}
+	if data, _ := os.ReadFile("config.json"); string(data) != `{"pins": [` {
+		t.Errorf("config.json overwritten: %s", data)
+	}
+}
+
+func TestParseFunctions(t *testing.T) {
+	tests := []struct {
+		input	string
+		want	[]Function
+	}{
+		{input: "", want: nil},
+		{
+			input: "FromSuperior=1  FromSuperiorAndDescendants=3",
+			want: []Function{{Name: "FromSuperior", Scope: 1}, {Name: "FromSuperiorAndDescendants", Scope: 3}},
+		},
+		{
+			input: "UsingRef=2 Broken Bad=x A=1=2",
+			want: []Function{{Name: "UsingRef", Scope: 2}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if got := parseFunctions(tt.input); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFunctions(t *testing.T) {
+	no := false
+	tests := []struct {
+		name, config	string
+		want		[]Function
+	}{
+		{
+			name: "no config.json keeps the defaults",
+			want: New().Config.Functions,
+		},
+		{
+			name: "none given",
+			config: `{"deck": "x"}`,
+			want: []Function{},
+		},
+		{
+			name: "shorthand",
+			config: `{"functions": "FromSuperior=2 UsingRef=1"}`,
+			want: []Function{{Name: "FromSuperior", Scope: 2}, {Name: "UsingRef", Scope: 1}},
+		},
+		{
+			name: "objects with options",
+			config: `{"functions": [
+				{"name": "FromSuperior", "scope": 1},
+				{"name": "FromSuperiorAndDescendants", "scope": 3, "collect": ["img", "table"],
+				"containers": ["figure"], "maxObjects": 4, "maxBytes": 1000, "captions": false}
+			]}`,
+			want: []Function{
+				{Name: "FromSuperior", Scope: 1},
+				{
+					Name: "FromSuperiorAndDescendants", Scope: 3,
+					Collect: []string{"img", "table"}, Containers: []string{"figure"},
+					MaxObjects: 4, MaxBytes: 1000, Captions: &no,
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inTempDir(t, tt.config)
+			m := testMeta()
+			if err := m.LoadConfig(); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(m.Config.Functions, tt.want) {
+				t.Errorf("got %+v, want %+v", m.Config.Functions, tt.want)
+			}
+		})
+	}
+}
+
+// every slice and map of the config, including those added later, must be copied
+func TestConfigClone(t *testing.T) {
+	captions := true
+	c := New().Config
+	c.Tags = []string{"bones"}
+	c.Pins = []Pin{{File: "a.png"}}
+	c.Include, c.Exclude = []string{"Anatomy/**"}, []string{"Anatomy/Skull"}
+	c.Filters = map[string]SectionFilter{"Wikipedia": {Include: []string{"**"}, Exclude: []string{"See also"}}}
+	c.CaptionPatterns = []string{"^Plate"}
+	c.Functions[0].Collect = []string{"img"}
+	c.Functions[0].Containers = []string{}
+	c.Functions[0].Captions = &captions
+	clone := c.Clone()
+	if !reflect.DeepEqual(c, clone) {
+		t.Fatalf("got %+v, want %+v", clone, c)
+	}
+	if clone.Functions[0].Containers == nil {
+		t.Error("an empty list of containers became nil")
+	}
+	var check func(path string, a, b reflect.Value)
+	check = func(path string, a, b reflect.Value) {
+		// an empty slice has nothing to share
+		if k := a.Kind(); (k == reflect.Slice && a.Len() != 0 || (k == reflect.Map || k == reflect.Pointer) && !a.IsNil()) &&
+			a.UnsafePointer() == b.UnsafePointer() {
+			t.Errorf("%s is shared", path)
+		}
+		switch a.Kind() {
+		case reflect.Struct:
+			for i := 0; i < a.NumField(); i++ {
+				check(path+"."+a.Type().Field(i).Name, a.Field(i), b.Field(i))
+			}
+		case reflect.Slice:
+			for i := 0; i < a.Len(); i++ {
+				check(fmt.Sprintf("%s[%d]", path, i), a.Index(i), b.Index(i))
+			}
+		case reflect.Map:
+			for _, key := range a.MapKeys() {
+				check(fmt.Sprintf("%s[%v]", path, key), a.MapIndex(key), b.MapIndex(key))
+			}
+		case reflect.Pointer:
+			if !a.IsNil() {
+				check(path, a.Elem(), b.Elem())
+			}
+		}
+	}
+	check("Config", reflect.ValueOf(c), reflect.ValueOf(clone))
+}